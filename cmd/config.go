@@ -0,0 +1,194 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// netroConfig is the content of the configuration file
+type netroConfig struct {
+	// Alias maps a command name of the user's choosing to the command line it stands for,
+	// e.g. webcheck: "curl -I -L"
+	Alias map[string]string `yaml:"alias"`
+}
+
+// defaultConfigPath returns the configuration file read unless --config names another
+func defaultConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".netro.yaml"), nil
+}
+
+// loadConfig reads the configuration file. A missing default file is an empty
+// configuration; a file named with --config must exist. Unknown settings in the default
+// file, e.g. ones added for a newer netro, only cause a warning, so that they don't stop
+// every command from running; in a file named with --config they are an error.
+func loadConfig(path string) (netroConfig, error) {
+	var config netroConfig
+	explicit := path != ""
+	if !explicit {
+		var err error
+		path, err = defaultConfigPath()
+		if err != nil {
+			return config, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		if explicit || yaml.Unmarshal(data, &config) != nil {
+			return config, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: ignoring unknown settings in %s: %v\n", path, err)
+	}
+	return config, nil
+}
+
+// globalValueFlags are the root command's flags that take a separate value, which must be
+// skipped when looking for the command name
+var globalValueFlags = map[string]bool{"--config": true, "--timeout": true, "--log-file": true}
+
+// configFlag returns the value of --config from the command line, which is needed before
+// cobra parses it to know where the aliases are defined
+func configFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if value, ok := strings.CutPrefix(arg, "--config="); ok {
+			return value
+		}
+		if arg == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// expandAliases replaces an alias in the command position of args, the first argument
+// that isn't a global flag, with the command line it stands for. Aliases may refer to
+// other aliases, but not to themselves; built-in commands can't be overridden.
+func expandAliases(args []string, aliases map[string]string, builtin func(string) bool) ([]string, error) {
+	pos := 0
+	for pos < len(args) && strings.HasPrefix(args[pos], "-") {
+		if globalValueFlags[args[pos]] {
+			pos++
+		}
+		pos++
+	}
+
+	seen := make(map[string]bool)
+	for pos < len(args) {
+		name := args[pos]
+		expansion, ok := aliases[name]
+		if !ok || builtin(name) {
+			return args, nil
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("alias %q is recursive", name)
+		}
+		seen[name] = true
+
+		words, err := splitCommandLine(expansion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alias %q: %v", name, err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("alias %q is empty", name)
+		}
+		args = append(append(append([]string{}, args[:pos]...), words...), args[pos+1:]...)
+	}
+	return args, nil
+}
+
+// splitCommandLine splits an alias into words at spaces, like a shell would, keeping
+// text in single or double quotes together and honoring backslash escapes outside them
+func splitCommandLine(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// applyAliases expands an alias on the command line, as defined in the configuration
+// file, before cobra looks up the command
+func applyAliases() error {
+	args := os.Args[1:]
+	config, err := loadConfig(configFlag(args))
+	if err != nil {
+		return err
+	}
+	if len(config.Alias) == 0 {
+		return nil
+	}
+	expanded, err := expandAliases(args, config.Alias, isBuiltinCommand)
+	if err != nil {
+		return err
+	}
+	rootCmd.SetArgs(expanded)
+	return nil
+}
+
+// isBuiltinCommand reports whether name is one of netro's own commands, which aliases
+// can't replace
+func isBuiltinCommand(name string) bool {
+	// cobra only adds these once the command line is executed
+	if name == "help" || name == "completion" {
+		return true
+	}
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Name() == name || cmd.HasAlias(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	got, err := splitCommandLine(`curl -w '%{http_code} done' -H "X-A: b c" a\ b`)
+	if err != nil {
+		t.Fatalf("splitCommandLine returned an unexpected error: %v", err)
+	}
+	want := []string{"curl", "-w", "%{http_code} done", "-H", "X-A: b c", "a b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitCommandLine = %q, want %q", got, want)
+	}
+	if _, err := splitCommandLine(`curl 'open`); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestExpandAliases(t *testing.T) {
+	aliases := map[string]string{
+		"webcheck": "curl -I -L",
+		"home":     "webcheck https://example.com",
+		"loop":     "again",
+		"again":    "loop",
+		"dig":      "ping",
+	}
+	builtin := func(name string) bool { return name == "dig" || name == "curl" }
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"webcheck", "https://x"}, []string{"curl", "-I", "-L", "https://x"}},
+		{[]string{"--timeout", "5s", "home", "-v"}, []string{"--timeout", "5s", "curl", "-I", "-L", "https://example.com", "-v"}},
+		{[]string{"dig", "example.com"}, []string{"dig", "example.com"}},
+		{[]string{"--help"}, []string{"--help"}},
+	}
+	for _, tt := range tests {
+		got, err := expandAliases(tt.args, aliases, builtin)
+		if err != nil {
+			t.Errorf("expandAliases(%q) returned an unexpected error: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandAliases(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	if _, err := expandAliases([]string{"loop"}, aliases, builtin); err == nil {
+		t.Error("expected an error for a recursive alias")
+	}
+}
+
+func TestLoadConfigUnknownSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := filepath.Join(home, ".netro.yaml")
+	content := "alias:\n  webcheck: curl -I -L\ncolor: auto\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default file still loads, without the unknown setting
+	config, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("loadConfig returned error: %v", err)
+	}
+	if config.Alias["webcheck"] != "curl -I -L" {
+		t.Errorf("got aliases %v", config.Alias)
+	}
+
+	// A file named explicitly is checked strictly
+	if _, err := loadConfig(path); err == nil {
+		t.Error("loadConfig with --config and an unknown setting returned no error")
+	}
+}
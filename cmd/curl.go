@@ -5,28 +5,78 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 // curlCmd represents the curl command
 var curlCmd = &cobra.Command{
-	Use:   "curl [URL]",
+	Use:   "curl [URL...]",
 	Short: "Perform HTTP requests like curl",
 	Long: `Netro's curl command lets you perform HTTP requests similar to the original curl utility. 
-It supports proxies (-x), payloads (-d), multiple headers (-H), HTTP methods (-X), verbose output (-v), TLS details for HTTPS requests, and the ability to skip TLS verification (-k).`,
+It supports proxies (-x), payloads (-d, or as query parameters with -G), multiple headers (-H), HTTP methods (-X), verbose output (-v), TLS details for HTTPS requests, and the ability to skip TLS verification (-k).
+Several URLs can be given; they are fetched one after another, or concurrently with --parallel.
+A failed transfer doesn't stop the others, and a summary at the end lists the outcome of each URL.
+--trace-ascii records all transfers in the one file, each section labelled with its URL.
+In output file names (-o), "#1" is replaced by the position of the URL on the command line.
+With --fail, HTTP errors (status 400 and above) print no body and exit with code 22, as in curl.
+With --status-exit, the exit code encodes the status class: 0 for 2xx, and by default 1 for 1xx,
+3 for 3xx, 4 for 4xx and 5 for 5xx; --status-exit-codes overrides the mapping (e.g. 3xx=0,4xx=10).
+With several URLs, the exit code is the highest one among the failed transfers.
+Responses are requested with gzip and decompressed transparently, as curl does with --compressed;
+--raw still asks for gzip but keeps the body exactly as received and reports its Content-Encoding.
+Use --http-version 1.1 or 2 to pin the protocol; with -v the negotiated protocol and ALPN value are shown.
+HTTP/2 is only available over TLS, and HTTP/3 (QUIC) is not supported.
+Downloads to a file (-o) show a progress line on stderr when it is a terminal; -s/--silent hides it.
+--inspect sends an OPTIONS (CORS preflight) and a HEAD request instead and summarizes the endpoint's
+allowed methods, CORS policy, content type, caching and security headers in a table.
+--pinnedpubkey sha256//<base64> trusts a server by the SHA-256 hash of its public key instead of the CA
+chain, e.g. for self-signed backends; unlike -k, any other key is rejected.
+--graphql posts a GraphQL query (with optional --variables) as {"query": ..., "variables": ...} JSON.
+--keepalive-probe N sends N requests one after another over the same transport and shows for each
+whether it opened a new connection or reused the previous one, to check that keep-alive works
+against a server or through a proxy.
+-w/--write-out prints a template to stdout after the transfer, with %{name} replaced by http_code,
+http_version, content_type, url_effective, remote_ip, remote_port, size_download, time_namelookup,
+time_connect, time_appconnect, time_starttransfer or time_total (seconds), and \n, \t and %% unescaped.
+--request-file replays a raw HTTP request (request line, headers and body) captured by another tool;
+the URL only supplies the scheme and host, so the method, path, query, headers and body are sent as captured.
+--show-ip prints the IP address and port the request connected to on stderr (-v shows it as well),
+e.g. to see which server of a round-robin or GeoDNS name answered; through a proxy it is the proxy's.
+--pretty formats the printed body by its Content-Type: JSON and XML are indented and HTML is reduced
+to its text; other types, and bodies that don't parse, are printed as received. Files (-o) are untouched.
+--cert-expiry-warn 14d warns on stderr when the server's certificate expires within 14 days; with -k
+and --fail, an already expired certificate exits with code 60, so a cron job can monitor certificates.
+--har writes the requests and responses (headers, bodies and timings) to a HAR 1.2 file that browser
+devtools and other HTTP tools can import; binary bodies are stored base64-encoded. With several URLs,
+all transfers go to the one file; after redirects, the last request and its response are recorded.
+The file is readable only by you, and credentials (Authorization, Cookie and Set-Cookie headers, cookie
+values and URL passwords) are masked unless --har-sensitive is given.
+--headers-file headers.txt adds the "Name: Value" headers listed in the file, one per line (blank lines
+and lines starting with # are skipped), e.g. to reuse a common header set; -H headers with the same name
+replace the file's.
+--interface eth1 (or a local address such as 10.0.0.2) makes the connections from that interface's
+address, to test a particular link or VPN on a multi-homed host; the kernel's routing table still picks
+the outgoing route, and only server addresses of the source address's IP family are tried.`,
 	Args: cobra.MinimumNArgs(1), // At least one argument is required (the URL)
-	Run: func(cmd *cobra.Command, args []string) {
-		url := args[0]
-
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Fetch flags
 		proxy, _ := cmd.Flags().GetString("proxy")
 		data, _ := cmd.Flags().GetString("data")
@@ -34,13 +84,269 @@ It supports proxies (-x), payloads (-d), multiple headers (-H), HTTP methods (-X
 		method, _ := cmd.Flags().GetString("method")
 		verbose, _ := cmd.Flags().GetBool("verbose")
 		insecure, _ := cmd.Flags().GetBool("insecure")
+		pinnedPubKey, _ := cmd.Flags().GetString("pinnedpubkey")
+		traceFile, _ := cmd.Flags().GetString("trace-ascii")
+		limitRate, _ := cmd.Flags().GetString("limit-rate")
+		output, _ := cmd.Flags().GetString("output")
+		parallel, _ := cmd.Flags().GetBool("parallel")
+		parallelMax, _ := cmd.Flags().GetInt("parallel-max")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		unixSocket, _ := cmd.Flags().GetString("unix-socket")
+		get, _ := cmd.Flags().GetBool("get")
+		raw, _ := cmd.Flags().GetBool("raw")
+		uploadFile, _ := cmd.Flags().GetString("upload-file")
+		expect100Timeout, _ := cmd.Flags().GetDuration("expect100-timeout")
+		fail, _ := cmd.Flags().GetBool("fail")
+		statusExit, _ := cmd.Flags().GetBool("status-exit")
+		statusExitCodes, _ := cmd.Flags().GetStringToInt("status-exit-codes")
+		userAgent, _ := cmd.Flags().GetString("user-agent")
+		retries, _ := cmd.Flags().GetInt("retry")
+		retryDelay, _ := cmd.Flags().GetDuration("retry-delay")
+		retryMaxTime, _ := cmd.Flags().GetDuration("retry-max-time")
+		retryOnStatus, _ := cmd.Flags().GetStringSlice("retry-on-status")
+		etagSave, _ := cmd.Flags().GetString("etag-save")
+		etagCompare, _ := cmd.Flags().GetString("etag-compare")
+		httpVersion, _ := cmd.Flags().GetString("http-version")
+		silent, _ := cmd.Flags().GetBool("silent")
+		dataURLEncode, _ := cmd.Flags().GetStringArray("data-urlencode")
+		inspect, _ := cmd.Flags().GetBool("inspect")
+		graphql, _ := cmd.Flags().GetString("graphql")
+		variables, _ := cmd.Flags().GetString("variables")
+		requestFile, _ := cmd.Flags().GetString("request-file")
+		useOAuth2, _ := cmd.Flags().GetBool("oauth2")
+		oauth2TokenURL, _ := cmd.Flags().GetString("oauth2-token-url")
+		oauth2ClientID, _ := cmd.Flags().GetString("oauth2-client-id")
+		oauth2ClientSecret, _ := cmd.Flags().GetString("oauth2-client-secret")
+		writeOut, _ := cmd.Flags().GetString("write-out")
+		keepAliveProbe, _ := cmd.Flags().GetInt("keepalive-probe")
+		certExpiryWarn, _ := cmd.Flags().GetString("cert-expiry-warn")
+		pretty, _ := cmd.Flags().GetBool("pretty")
+		showIP, _ := cmd.Flags().GetBool("show-ip")
+		harFile, _ := cmd.Flags().GetString("har")
+		harSensitive, _ := cmd.Flags().GetBool("har-sensitive")
+		iface, _ := cmd.Flags().GetString("interface")
+		headersFile, _ := cmd.Flags().GetString("headers-file")
+
+		// Catch template mistakes before any request is sent
+		if _, err := expandWriteOut(writeOut, transferInfo{}); err != nil {
+			fatalf("Error executing curl: %v", err)
+		}
+
+		// Headers from --headers-file come first, without those -H sets
+		if headersFile != "" {
+			fileHeaders, err := readHeadersFile(headersFile)
+			if err != nil {
+				fatalf("Error executing curl: invalid --headers-file: %v", err)
+			}
+			headers = mergeHeaders(fileHeaders, headers)
+		}
+
+		// A GraphQL request is a JSON body posted like any other -d data
+		if graphql != "" {
+			body, err := graphQLBody(graphql, variables)
+			if err != nil {
+				fatalf("Error executing curl: %v", err)
+			}
+			data = body
+			if !hasHeader(headers, "Content-Type") {
+				headers = append(headers, "Content-Type: application/json")
+			}
+		} else if variables != "" {
+			fatalf("Error executing curl: --variables requires --graphql")
+		}
+
+		// Like curl, URL-encoded pieces are joined to the -d data with "&"
+		for _, spec := range dataURLEncode {
+			field, err := encodeFormField(spec)
+			if err != nil {
+				fatalf("Error executing curl: invalid --data-urlencode: %v", err)
+			}
+			if data != "" {
+				data += "&"
+			}
+			data += field
+		}
 
-		// Execute the curl logic
-		err := executeCurl(url, proxy, data, headers, method, verbose, insecure)
+		retryStatuses, err := parseStatusList(retryOnStatus)
 		if err != nil {
-			fmt.Printf("Error executing curl: %v\n", err)
-			os.Exit(1)
+			fatalf("Error executing curl: invalid --retry-on-status: %v", err)
+		}
+
+		// Like curl, uploading a file defaults to PUT
+		if uploadFile != "" && !cmd.Flags().Changed("method") {
+			method = "PUT"
+		}
+
+		// Like curl, -d implies POST unless a method is given explicitly or -G moves the data into the URL
+		if data != "" && !get && !cmd.Flags().Changed("method") {
+			method = "POST"
+		}
+
+		switch httpVersion {
+		case "", "1.1", "2":
+			// Supported by the standard transport
+		case "3":
+			fatalf("Error executing curl: HTTP/3 not supported (it needs a QUIC transport); use --http-version 1.1 or 2")
+		default:
+			fatalf("Error executing curl: invalid --http-version %q (use 1.1, 2 or 3)", httpVersion)
+		}
+
+		var pinnedPubKeys []string
+		if pinnedPubKey != "" {
+			pinnedPubKeys, err = parsePinnedPubKeys(pinnedPubKey)
+			if err != nil {
+				fatalf("Error executing curl: invalid --pinnedpubkey: %v", err)
+			}
+		}
+
+		var expiryWindow time.Duration
+		if certExpiryWarn != "" {
+			expiryWindow, err = parseExpiryWindow(certExpiryWarn)
+			if err != nil {
+				fatalf("Error executing curl: invalid --cert-expiry-warn: %v", err)
+			}
+		}
+
+		// Send from an address of this host, given directly or as the interface that has it
+		var source string
+		if iface != "" {
+			source, err = resolveBindAddress(iface)
+			if err != nil {
+				fatalf("Error executing curl: invalid --interface: %v", err)
+			}
+		}
+
+		var rateLimit int64
+		if limitRate != "" {
+			var err error
+			rateLimit, err = parseByteSize(limitRate)
+			if err != nil {
+				fatalf("Error executing curl: invalid --limit-rate: %v", err)
+			}
+		}
+
+		opts := curlOptions{
+			proxy:          proxy,
+			data:           data,
+			headers:        headers,
+			method:         method,
+			verbose:        verbose,
+			insecure:       insecure,
+			pinnedPubKeys:  pinnedPubKeys,
+			rateLimit:      rateLimit,
+			output:         output,
+			timeout:        timeout,
+			unixSocket:     unixSocket,
+			get:            get,
+			raw:            raw,
+			uploadFile:     uploadFile,
+			expect100:      expect100Timeout,
+			fail:           fail,
+			userAgent:      userAgent,
+			retries:        retries,
+			retryDelay:     retryDelay,
+			retryMaxTime:   retryMaxTime,
+			etagSave:       etagSave,
+			etagCompare:    etagCompare,
+			httpVersion:    httpVersion,
+			requestFile:    requestFile,
+			writeOut:       writeOut,
+			certExpiryWarn: expiryWindow,
+			pretty:         pretty,
+			showIP:         showIP,
+			source:         source,
+			retryStatuses:  retryStatuses,
+			// Concurrent transfers would fight over the single progress line
+			progress: !silent && !parallel && term.IsTerminal(int(os.Stderr.Fd())),
+		}
+		if harFile != "" {
+			opts.har = &harRecorder{path: harFile, sensitive: harSensitive}
+		} else if harSensitive {
+			fatalf("Error executing curl: --har-sensitive requires --har")
+		}
+		// Authenticate with a token from a client-credentials grant
+		if useOAuth2 {
+			if oauth2TokenURL == "" || oauth2ClientID == "" || oauth2ClientSecret == "" {
+				fatalf("Error executing curl: --oauth2 requires --oauth2-token-url, --oauth2-client-id and --oauth2-client-secret")
+			}
+			opts.oauth2 = &oauth2Config{
+				tokenURL:     oauth2TokenURL,
+				clientID:     oauth2ClientID,
+				clientSecret: oauth2ClientSecret,
+			}
+		} else if oauth2TokenURL != "" || oauth2ClientID != "" || oauth2ClientSecret != "" {
+			fatalf("Error executing curl: the --oauth2-* options require --oauth2")
 		}
+		if statusExit {
+			opts.statusExitCodes = defaultStatusExitCodes()
+			for class, code := range statusExitCodes {
+				if _, ok := opts.statusExitCodes[class]; !ok {
+					fatalf("Error executing curl: invalid status class %q in --status-exit-codes (use 1xx-5xx)", class)
+				}
+				opts.statusExitCodes[class] = code
+			}
+		}
+
+		// Summarize what each endpoint allows instead of fetching it
+		if inspect {
+			for _, url := range args {
+				if err := inspectEndpoint(url, opts); err != nil {
+					fatalf("Error executing curl: %v", err)
+				}
+			}
+			return nil
+		}
+
+		// Repeat the request over one transport to see whether connections are reused
+		if keepAliveProbe < 0 {
+			fatalf("Error executing curl: --keepalive-probe must not be negative")
+		}
+		if keepAliveProbe > 0 {
+			for _, url := range args {
+				if err := probeKeepAlive(url, opts, keepAliveProbe); err != nil {
+					fatalf("Error executing curl: %v", err)
+				}
+			}
+			return nil
+		}
+
+		// Every transfer writes to the one trace file
+		if traceFile != "" {
+			file, err := os.Create(traceFile)
+			if err != nil {
+				fatalf("Error executing curl: failed to create trace file: %v", err)
+			}
+			defer file.Close()
+			opts.trace = &traceWriter{file: file}
+		}
+
+		// Several URLs can be fetched concurrently, each to its own output file; otherwise
+		// they are fetched in turn. Either way a failure doesn't stop the rest.
+		if parallel {
+			err = executeCurlParallel(args, opts, parallelMax)
+		} else {
+			err = executeCurlSequential(args, opts)
+		}
+
+		// Save the HAR file whatever the outcome, with the transfers that did finish; the
+		// failing runs are the ones most worth sharing
+		if harErr := writeHAR(opts); harErr != nil {
+			fmt.Printf("Error executing curl: %v\n", harErr)
+			if err == nil {
+				exitCommand(1, harErr)
+			}
+		}
+
+		// Status-derived exit codes are handed to Execute
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			cmd.SilenceUsage = true
+			return err
+		}
+		if err != nil {
+			fatalf("Error executing curl: %v", err)
+		}
+		return nil
 	},
 }
 
@@ -51,33 +357,337 @@ func init() {
 	curlCmd.Flags().StringP("proxy", "x", "", "Specify a proxy to use")
 	curlCmd.Flags().StringP("data", "d", "", "HTTP POST data (triggers POST request or other methods with -X)")
 	curlCmd.Flags().StringArrayP("header", "H", []string{}, "Specify multiple headers (can be used multiple times)")
+	curlCmd.Flags().String("headers-file", "", "Read headers from this file, one \"Name: Value\" per line; -H headers with the same name take precedence")
 	curlCmd.Flags().StringP("method", "X", "GET", "Specify the HTTP method to use (GET, POST, PUT, DELETE, etc.)")
 	curlCmd.Flags().BoolP("verbose", "v", false, "Enable verbose output to show request and response details, including TLS details")
 	curlCmd.Flags().BoolP("insecure", "k", false, "Allow insecure server connections when using SSL (skip TLS certificate verification)")
+	curlCmd.Flags().String("pinnedpubkey", "", "Accept the server only if its public key has this hash (sha256//<base64>, several separated by ';'), instead of verifying the CA chain")
+	curlCmd.MarkFlagsMutuallyExclusive("pinnedpubkey", "insecure")
+	curlCmd.Flags().String("trace-ascii", "", "Write the full request and response (headers and body) to the given file")
+	curlCmd.Flags().String("limit-rate", "", "Maximum transfer rate in bytes per second, with optional k/m/g suffix (e.g. 100k)")
+	curlCmd.Flags().StringP("output", "o", "", "Write the response body to a file instead of stdout (\"#1\" is replaced by the URL's position)")
+	curlCmd.Flags().Bool("parallel", false, "Fetch multiple URLs concurrently (requires -o with \"#1\")")
+	curlCmd.Flags().Int("parallel-max", 5, "Maximum number of concurrent transfers with --parallel")
+	curlCmd.Flags().String("unix-socket", "", "Connect through this Unix domain socket instead of the network (e.g. /var/run/docker.sock)")
+	curlCmd.Flags().BoolP("get", "G", false, "Send the -d data as URL query parameters in a GET request instead of a request body")
+	curlCmd.Flags().Bool("raw", false, "Keep the response body exactly as sent on the wire, without decompressing it")
+	curlCmd.Flags().StringP("upload-file", "T", "", "Stream this file as the request body (PUT unless -X is given) without loading it into memory")
+	curlCmd.Flags().Duration("expect100-timeout", time.Second, "How long to wait for a 100 Continue response before sending a large upload anyway")
+	curlCmd.Flags().StringArray("data-urlencode", nil, "URL-encode and send form data: content, =content, name=content, @file, name@file or name=@file (repeatable)")
+	curlCmd.MarkFlagsMutuallyExclusive("data", "upload-file")
+	curlCmd.MarkFlagsMutuallyExclusive("data-urlencode", "upload-file")
+	curlCmd.Flags().String("graphql", "", "Send a GraphQL query (or @file) as a JSON POST body with Content-Type application/json")
+	curlCmd.Flags().String("variables", "", "JSON object of variables for --graphql, e.g. '{\"id\": 1}'")
+	curlCmd.MarkFlagsMutuallyExclusive("graphql", "data")
+	curlCmd.MarkFlagsMutuallyExclusive("graphql", "data-urlencode")
+	curlCmd.MarkFlagsMutuallyExclusive("graphql", "upload-file")
+	curlCmd.MarkFlagsMutuallyExclusive("graphql", "get")
+	curlCmd.Flags().String("request-file", "", "Replay the raw HTTP request in this file (method, path, headers and body) against the URL's host")
+	for _, flag := range []string{"data", "data-urlencode", "upload-file", "graphql", "get", "method"} {
+		curlCmd.MarkFlagsMutuallyExclusive("request-file", flag)
+	}
+	curlCmd.Flags().StringP("user-agent", "A", "", "User-Agent header to send (default \"netro/<version>\"); -H User-Agent: takes precedence")
+	curlCmd.Flags().Int("retry", 0, "Retry the request this many times on transport errors and on 408, 429 and 5xx responses")
+	curlCmd.Flags().Duration("retry-delay", time.Second, "Time to wait between retries, unless a 429 or 503 response has a Retry-After header")
+	curlCmd.Flags().Duration("retry-max-time", 0, "Stop retrying once this much time has passed since the first attempt, capping Retry-After waits (0 for no limit)")
+	curlCmd.Flags().StringSlice("retry-on-status", nil, "With --retry, retry only on these HTTP status codes (e.g. 502,503,504)")
+	curlCmd.Flags().String("etag-save", "", "Save the response's ETag to this file")
+	curlCmd.Flags().String("etag-compare", "", "Send If-None-Match with the ETag stored in this file; 304 Not Modified succeeds without a body")
+	curlCmd.Flags().String("http-version", "", "Require this HTTP version: 1.1 or 2 (HTTP/3 is not supported)")
+	curlCmd.Flags().Bool("inspect", false, "Probe the URL with OPTIONS and HEAD and summarize CORS, allowed methods, caching and security headers")
+	curlCmd.Flags().Int("keepalive-probe", 0, "Send this many sequential requests over one transport and report which reused the connection")
+	curlCmd.MarkFlagsMutuallyExclusive("keepalive-probe", "inspect")
+	curlCmd.MarkFlagsMutuallyExclusive("keepalive-probe", "parallel")
+	curlCmd.MarkFlagsMutuallyExclusive("keepalive-probe", "upload-file")
+	curlCmd.MarkFlagsMutuallyExclusive("keepalive-probe", "request-file")
+	curlCmd.Flags().Bool("oauth2", false, "Get an access token with an OAuth 2.0 client-credentials grant and send it as a Bearer token")
+	curlCmd.Flags().String("oauth2-token-url", "", "Token endpoint for --oauth2")
+	curlCmd.Flags().String("oauth2-client-id", "", "Client ID for --oauth2")
+	curlCmd.Flags().String("oauth2-client-secret", "", "Client secret for --oauth2")
+	curlCmd.Flags().StringP("write-out", "w", "", "Print this template after the transfer, e.g. '%{http_code} %{time_total}\\n' (see the help text for variables)")
+	curlCmd.Flags().Bool("show-ip", false, "Print the IP address and port the request actually connected to (shown with -v too)")
+	curlCmd.Flags().Bool("pretty", false, "Format the printed body by its Content-Type: indent JSON and XML, render HTML as plain text")
+	curlCmd.MarkFlagsMutuallyExclusive("pretty", "raw")
+	curlCmd.Flags().String("har", "", "Write each request and response, with headers, bodies and timings, to this file in HAR format")
+	curlCmd.MarkFlagsMutuallyExclusive("har", "inspect")
+	curlCmd.Flags().Bool("har-sensitive", false, "Keep credential headers, cookies and URL passwords in the --har file instead of masking them")
+	curlCmd.Flags().String("interface", "", "Make connections from this local address, or from the address of this interface (e.g. eth1 or 10.0.0.2)")
+	curlCmd.MarkFlagsMutuallyExclusive("interface", "unix-socket")
+	curlCmd.MarkFlagsMutuallyExclusive("har", "keepalive-probe")
+	curlCmd.Flags().BoolP("silent", "s", false, "Don't show the download progress meter")
+	curlCmd.Flags().String("cert-expiry-warn", "", "Warn when the server certificate expires within this window, e.g. 14d or 72h")
+	curlCmd.Flags().BoolP("fail", "f", false, "Fail on HTTP errors (status >= 400): print no body and exit with code 22")
+	curlCmd.Flags().Bool("status-exit", false, "Set the exit code from the response status class (0 for 2xx; see --status-exit-codes)")
+	curlCmd.Flags().StringToInt("status-exit-codes", nil, "Exit codes per status class for --status-exit (default 1xx=1,3xx=3,4xx=4,5xx=5)")
 }
 
-// executeCurl performs the HTTP request based on the provided flags
-func executeCurl(urlStr, proxy, data string, headers []string, method string, verbose, insecure bool) error {
-	// Create HTTP transport
-	transport := &http.Transport{
-		// Set TLS client configuration
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: insecure, // Skip certificate verification if insecure mode is enabled
-		},
+// curlOptions holds the settings collected from the curl command's flags
+type curlOptions struct {
+	proxy           string
+	data            string
+	headers         []string
+	method          string
+	verbose         bool
+	insecure        bool
+	pinnedPubKeys   []string       // base64 SHA-256 hashes of the accepted server public keys
+	trace           *traceWriter   // --trace-ascii file shared by all transfers, if set
+	rateLimit       int64          // bytes per second, 0 for unlimited
+	output          string         // file to write the response body to, stdout if empty
+	timeout         time.Duration  // limit for the whole request, 0 for none
+	unixSocket      string         // Unix socket path to connect through; the URL host only sets the Host header
+	get             bool           // append data to the URL query and send a GET instead of a body
+	raw             bool           // leave compressed response bodies as received
+	uploadFile      string         // file streamed as the request body
+	expect100       time.Duration  // wait for 100 Continue before sending large uploads
+	fail            bool           // treat HTTP errors as failures without printing the body
+	userAgent       string         // User-Agent unless set with -H; "netro/<version>" if empty
+	retries         int            // extra attempts after a failure
+	retryDelay      time.Duration  // pause between attempts, unless the server sends Retry-After
+	retryMaxTime    time.Duration  // bound on the time spent retrying, 0 for none
+	etagSave        string         // file the response ETag is written to
+	etagCompare     string         // file holding the ETag sent as If-None-Match
+	httpVersion     string         // "1.1" or "2" to pin the protocol; Go's default if empty
+	retryStatuses   map[int]bool   // statuses that are retried; 408, 429 and 5xx if empty
+	progress        bool           // show a progress line on stderr while downloading to a file
+	oauth2          *oauth2Config  // client credentials to obtain a Bearer token with, if set
+	requestFile     string         // raw HTTP request replayed instead of building one from the flags
+	writeOut        string         // template printed to stdout after the transfer, if set
+	certExpiryWarn  time.Duration  // warn when the server certificate expires within this window
+	pretty          bool           // indent JSON and XML bodies and render HTML as text on stdout
+	showIP          bool           // report the remote IP address the request was sent to on stderr
+	har             *harRecorder   // collects the transfers for --har, if set
+	source          string         // local IP address connections are made from; chosen by the kernel if empty
+	statusExitCodes map[string]int // exit code per status class ("4xx"), nil unless --status-exit
+}
+
+// defaultStatusExitCodes returns the exit codes used by --status-exit for each status class
+func defaultStatusExitCodes() map[string]int {
+	return map[string]int{"1xx": 1, "2xx": 0, "3xx": 3, "4xx": 4, "5xx": 5}
+}
+
+// failExitCode is curl's exit code for HTTP errors with --fail
+const failExitCode = 22
+
+// statusError converts the response status into an exitError when --status-exit or
+// --fail ask for it, or returns nil when the process should exit successfully
+func statusError(resp *http.Response, opts curlOptions) error {
+	if opts.statusExitCodes != nil {
+		class := fmt.Sprintf("%dxx", resp.StatusCode/100)
+		code, ok := opts.statusExitCodes[class]
+		if !ok {
+			code = 1 // status codes outside 1xx-5xx
+		}
+		if code == 0 {
+			return nil
+		}
+		return &exitError{code: code, err: fmt.Errorf("HTTP status %s", resp.Status)}
 	}
+	if opts.fail && resp.StatusCode >= 400 {
+		return &exitError{code: failExitCode, err: fmt.Errorf("the requested URL returned error: %s", resp.Status)}
+	}
+	return nil
+}
 
-	// If a proxy is specified, set the proxy
-	if proxy != "" {
-		proxyURL, err := url.Parse(proxy)
+// encodeFormField converts a --data-urlencode argument into an encoded form field, as
+// curl does: "content" and "=content" encode the content, "name=content" encodes only the
+// content, and "@file", "name@file" and "name=@file" encode the contents of a file
+func encodeFormField(spec string) (string, error) {
+	name, content := "", spec
+	if i := strings.IndexAny(spec, "=@"); i >= 0 {
+		name = spec[:i]
+		content = spec[i+1:]
+		fromFile := spec[i] == '@'
+		if name != "" && strings.HasPrefix(content, "@") {
+			content, fromFile = content[1:], true
+		}
+		if fromFile {
+			data, err := os.ReadFile(content)
+			if err != nil {
+				return "", err
+			}
+			content = string(data)
+		}
+	}
+
+	if name == "" {
+		return url.QueryEscape(content), nil
+	}
+	return name + "=" + url.QueryEscape(content), nil
+}
+
+// graphQLBody builds the JSON body of a GraphQL request from the query, read from a file
+// when given as "@file", and the optional variables, which must be a JSON object
+func graphQLBody(query, variables string) (string, error) {
+	if strings.HasPrefix(query, "@") {
+		data, err := os.ReadFile(query[1:])
 		if err != nil {
-			return fmt.Errorf("invalid proxy URL: %v", err)
+			return "", fmt.Errorf("failed to read GraphQL query: %v", err)
 		}
-		transport.Proxy = http.ProxyURL(proxyURL)
+		query = string(data)
 	}
 
-	// Create HTTP client with the custom transport
-	client := &http.Client{
-		Transport: transport,
+	request := struct {
+		Query     string          `json:"query"`
+		Variables json.RawMessage `json:"variables,omitempty"`
+	}{Query: query}
+	if variables != "" {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(variables), &object); err != nil {
+			return "", fmt.Errorf("invalid --variables: must be a JSON object: %v", err)
+		}
+		request.Variables = json.RawMessage(variables)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// hasHeader reports whether one of the -H headers sets the named header
+func hasHeader(headers []string, name string) bool {
+	for _, header := range headers {
+		key, _, _ := strings.Cut(header, ":")
+		if strings.EqualFold(strings.TrimSpace(key), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readHeadersFile reads one "Name: Value" header per line for --headers-file, skipping
+// blank lines and lines starting with "#"
+func readHeadersFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var headers []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, _, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("line %d: invalid header format: %s", i+1, line)
+		}
+		headers = append(headers, line)
+	}
+	return headers, nil
+}
+
+// mergeHeaders adds the -H headers to those read from a file, dropping the file's
+// headers whose name -H sets
+func mergeHeaders(fileHeaders, headers []string) []string {
+	merged := make([]string, 0, len(fileHeaders)+len(headers))
+	for _, header := range fileHeaders {
+		name, _, _ := strings.Cut(header, ":")
+		if !hasHeader(headers, strings.TrimSpace(name)) {
+			merged = append(merged, header)
+		}
+	}
+	return append(merged, headers...)
+}
+
+// expectContinueThreshold is the upload size from which "Expect: 100-continue" is sent,
+// so the server can reject a request before the body is transferred
+const expectContinueThreshold = 1 << 20
+
+// expandOutputTemplate substitutes the 1-based URL position for "#1" in an output file name
+func expandOutputTemplate(template string, index int) string {
+	return strings.ReplaceAll(template, "#1", strconv.Itoa(index))
+}
+
+// executeCurlParallel fetches the URLs concurrently, running at most maxParallel transfers
+// at once, and prints a per-URL summary when all of them have finished
+func executeCurlParallel(urls []string, opts curlOptions, maxParallel int) error {
+	if !strings.Contains(opts.output, "#1") {
+		return fmt.Errorf("--parallel requires an output template containing \"#1\" (e.g. -o 'out-#1')")
+	}
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+
+	errs := make([]error, len(urls))
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			urlOpts := opts
+			urlOpts.output = expandOutputTemplate(opts.output, i+1)
+			errs[i] = executeCurl(url, urlOpts)
+		}(i, url)
+	}
+	wg.Wait()
+	return summarizeTransfers(urls, errs, opts.output)
+}
+
+// executeCurlSequential fetches the URLs one after another. A failed transfer doesn't
+// stop the others; when one of several URLs failed, the per-URL summary is printed at the end.
+func executeCurlSequential(urls []string, opts curlOptions) error {
+	errs := make([]error, len(urls))
+	failed := false
+	for i, url := range urls {
+		urlOpts := opts
+		urlOpts.output = expandOutputTemplate(opts.output, i+1)
+		errs[i] = executeCurl(url, urlOpts)
+		failed = failed || errs[i] != nil
+	}
+	if len(urls) == 1 || !failed {
+		return errs[0]
+	}
+	return summarizeTransfers(urls, errs, opts.output)
+}
+
+// summarizeTransfers prints the outcome of each transfer in command-line order and
+// returns an error if any of them failed. When failed transfers asked for exit codes
+// (--fail, --status-exit, an expired certificate), the highest one is kept.
+func summarizeTransfers(urls []string, errs []error, output string) error {
+	failed, code := 0, 0
+	fmt.Println("----- Summary -----")
+	for i, url := range urls {
+		var exitErr *exitError
+		switch {
+		case errs[i] != nil:
+			failed++
+			if errors.As(errs[i], &exitErr) {
+				code = max(code, exitErr.code)
+			}
+			fmt.Printf("[%d] FAILED %s: %v\n", i+1, url, errs[i])
+		case output != "":
+			fmt.Printf("[%d] OK     %s -> %s\n", i+1, url, expandOutputTemplate(output, i+1))
+		default:
+			fmt.Printf("[%d] OK     %s\n", i+1, url)
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%d of %d transfers failed", failed, len(urls))
+	if code > 0 {
+		return &exitError{code: code, err: err}
+	}
+	return err
+}
+
+// executeCurl performs the HTTP request based on the provided flags
+func executeCurl(urlStr string, opts curlOptions) error {
+	data := opts.data
+	method := opts.method
+	verbose := opts.verbose
+
+	client, err := newCurlClient(urlStr, opts)
+	if err != nil {
+		return err
 	}
 
 	// Default to GET method if no method is specified
@@ -85,25 +695,103 @@ func executeCurl(urlStr, proxy, data string, headers []string, method string, ve
 		method = "GET"
 	}
 
-	// Create the request, using the specified method
+	// With -G the data becomes part of the query string and the request is always a GET
+	if opts.get && data != "" {
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return fmt.Errorf("invalid URL: %v", err)
+		}
+		if u.RawQuery != "" {
+			u.RawQuery += "&" + data
+		} else {
+			u.RawQuery = data
+		}
+		urlStr = u.String()
+		method = "GET"
+		data = ""
+	}
+
+	// Create the request, using the specified method. Any method may carry a body,
+	// including PATCH and OPTIONS. A captured request is replayed as it was instead.
 	var req *http.Request
-	var err error
-	if data != "" {
-		req, err = http.NewRequest(method, urlStr, bytes.NewBuffer([]byte(data)))
+	if opts.requestFile != "" {
+		req, err = readRequestFile(opts.requestFile, urlStr)
+		if err != nil {
+			return err
+		}
 	} else {
-		req, err = http.NewRequest(method, urlStr, nil)
+		if data != "" {
+			req, err = http.NewRequest(method, urlStr, bytes.NewBufferString(data))
+		} else {
+			req, err = http.NewRequest(method, urlStr, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
 	}
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+
+	// Stream the upload file straight from disk with a known length
+	var uploadSize int64
+	if opts.uploadFile != "" {
+		file, err := os.Open(opts.uploadFile)
+		if err != nil {
+			return fmt.Errorf("failed to open upload file: %v", err)
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat upload file: %v", err)
+		}
+		uploadSize = info.Size()
+		req.Body = io.NopCloser(file)
+		req.ContentLength = uploadSize
+		// Rewind the file when the request is retried
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(file), nil
+		}
 	}
 
 	// Add headers to the request
-	for _, header := range headers {
-		parts := strings.SplitN(header, ":", 2)
-		if len(parts) != 2 {
-			return fmt.Errorf("invalid header format: %s", header)
+	if err := setRequestHeaders(req, opts); err != nil {
+		return err
+	}
+
+	if err := setOAuth2Header(req, opts); err != nil {
+		return err
+	}
+
+	// Make the request conditional on the ETag saved by an earlier run. A missing
+	// file is the first run, which fetches unconditionally.
+	var ifNoneMatch string
+	if opts.etagCompare != "" {
+		saved, err := os.ReadFile(opts.etagCompare)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read ETag file: %v", err)
+		}
+		ifNoneMatch = strings.TrimSpace(string(saved))
+		if ifNoneMatch != "" && req.Header.Get("If-None-Match") == "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
 		}
-		req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	// Without transparent decompression the transport no longer asks for gzip, so ask
+	// explicitly to get the same body the server would normally send
+	if opts.raw && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	// Let the server refuse a large upload before it is sent, as curl does
+	if uploadSize >= expectContinueThreshold && opts.expect100 > 0 && req.Header.Get("Expect") == "" {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	// Label the body as form data, as curl does, unless the caller chose a type
+	if data != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 
 	// If verbose is enabled, print the request details
@@ -118,26 +806,107 @@ func executeCurl(urlStr, proxy, data string, headers []string, method string, ve
 		if data != "" {
 			fmt.Printf("Body: %s\n", data)
 		}
+		if opts.uploadFile != "" {
+			fmt.Printf("Body: %d bytes from %s\n", uploadSize, opts.uploadFile)
+		}
+		if opts.requestFile != "" {
+			fmt.Printf("Body: %d bytes from %s\n", req.ContentLength, opts.requestFile)
+		}
 		fmt.Println("-------------------")
 	}
 
-	// Perform the request
-	resp, err := client.Do(req)
+	// Record the outgoing request in the trace file before sending it
+	if opts.trace != nil {
+		// Leave streamed uploads out of the dump so they are not read into memory
+		dump, err := httputil.DumpRequestOut(req, opts.uploadFile == "")
+		if err != nil {
+			return fmt.Errorf("failed to dump request: %v", err)
+		}
+		opts.trace.section("Send request to "+urlStr, dump)
+	}
+
+	// Time the transfer's phases for --write-out and --har, and note where it went for --show-ip and -v
+	var timer *transferTimer
+	if opts.writeOut != "" || opts.showIP || verbose || opts.har != nil {
+		timer = &transferTimer{start: time.Now()}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), timer.trace()))
+	}
+
+	// Perform the request, retrying transient failures when asked to
+	resp, err := doWithRetry(client, req, opts)
 	if err != nil {
 		return fmt.Errorf("request failed: %v", err)
 	}
 	defer resp.Body.Close()
 
+	// The server may still settle on HTTP/1.1, e.g. when it doesn't offer h2 over ALPN
+	if opts.httpVersion == "2" && resp.ProtoMajor != 2 {
+		return fmt.Errorf("server did not negotiate HTTP/2 (got %s)", resp.Proto)
+	}
+
+	// Record the response, including its body, in the trace file
+	if opts.trace != nil {
+		dump, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			return fmt.Errorf("failed to dump response: %v", err)
+		}
+		opts.trace.section("Recv response from "+urlStr, dump)
+	}
+
+	// Show the download's progress when the body goes to a file
+	var bodyReader io.Reader = newRateLimitedReader(resp.Body, opts.rateLimit)
+	var progress *progressReader
+	if opts.progress && opts.output != "" {
+		progress = newProgressReader(bodyReader, resp.ContentLength)
+		bodyReader = progress
+	}
+
 	// Read and print the response body using io.ReadAll (instead of ioutil.ReadAll)
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(bodyReader)
+	if progress != nil {
+		progress.finish()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read response body: %v", err)
 	}
 
+	// Archive the exchange as it happened, before --fail or --pretty have a say
+	if opts.har != nil {
+		reqBody, err := harRequestBody(resp.Request, req, opts)
+		if err != nil {
+			return fmt.Errorf("failed to read request body for HAR: %v", err)
+		}
+		opts.har.add(newHAREntry(resp, reqBody, body, timer.finish(resp, int64(len(body))), timer.start))
+	}
+
+	// Tell which of the host's addresses answered, e.g. behind DNS round-robin or GeoDNS
+	if opts.showIP && !verbose {
+		if ip, port := timer.remote(); ip != "" {
+			via := ""
+			if opts.proxy != "" {
+				via = " (proxy)"
+			}
+			fmt.Fprintf(os.Stderr, "Connected to %s (%s) port %s%s\n", resp.Request.URL.Hostname(), ip, port, via)
+		}
+	}
+
+	// Print the --write-out summary once everything else has been written
+	if opts.writeOut != "" {
+		defer func() {
+			// The template was checked before the transfer started
+			summary, _ := expandWriteOut(opts.writeOut, timer.finish(resp, int64(len(body))))
+			fmt.Print(summary)
+		}()
+	}
+
 	// If verbose is enabled, print the response details
 	if verbose {
 		fmt.Println("----- Response -----")
 		fmt.Printf("Status: %s\n", resp.Status)
+		fmt.Printf("Protocol: %s\n", resp.Proto)
+		if ip, port := timer.remote(); ip != "" {
+			fmt.Printf("Remote Address: %s\n", net.JoinHostPort(ip, port))
+		}
 		fmt.Println("Headers:")
 		for key, value := range resp.Header {
 			fmt.Printf("  %s: %s\n", key, strings.Join(value, ", "))
@@ -150,23 +919,306 @@ func executeCurl(urlStr, proxy, data string, headers []string, method string, ve
 		fmt.Println("--------------------")
 	}
 
-	// Print the response body
+	// Tell the user the raw body is still encoded
+	if opts.raw {
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+			fmt.Fprintf(os.Stderr, "Content-Encoding: %s (body left encoded)\n", encoding)
+		}
+	}
+
+	// Remember the validator for the next conditional request
+	if opts.etagSave != "" {
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			if err := os.WriteFile(opts.etagSave, []byte(etag+"\n"), 0644); err != nil {
+				return fmt.Errorf("failed to save ETag: %v", err)
+			}
+		}
+	}
+
+	// The stored ETag still matches: nothing changed, so there is no body to write
+	if ifNoneMatch != "" && resp.StatusCode == http.StatusNotModified {
+		fmt.Fprintf(os.Stderr, "Not modified (ETag %s)\n", ifNoneMatch)
+		return nil
+	}
+
+	// Check the certificate's remaining validity before anything is written
+	if resp.TLS != nil {
+		if err := checkCertExpiry(resp.TLS, resp.Request.URL.Hostname(), opts); err != nil {
+			return err
+		}
+	}
+
+	// With --fail, an HTTP error produces no output
+	if opts.fail && resp.StatusCode >= 400 {
+		return statusError(resp, opts)
+	}
+
+	// Write the response body to the output file, or print it
+	if opts.output != "" {
+		if err := os.WriteFile(opts.output, body, 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %v", err)
+		}
+		return statusError(resp, opts)
+	}
+	if opts.pretty {
+		body = prettyBody(body, resp.Header.Get("Content-Type"))
+	}
 	fmt.Printf("\nResponse Body:\n%s\n", string(body))
 
+	return statusError(resp, opts)
+}
+
+// setRequestHeaders adds the -H headers and the User-Agent to the request
+func setRequestHeaders(req *http.Request, opts curlOptions) error {
+	for _, header := range opts.headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid header format: %s", header)
+		}
+		req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	// Identify as netro rather than Go's default client, unless -H already set a User-Agent
+	if req.Header.Get("User-Agent") == "" {
+		userAgent := opts.userAgent
+		if userAgent == "" {
+			userAgent = "netro/" + strings.TrimPrefix(Version, "v")
+		}
+		req.Header.Set("User-Agent", userAgent)
+	}
 	return nil
 }
 
+// newCurlClient builds the HTTP client for a request to urlStr from the TLS, protocol,
+// Unix socket, proxy and timeout options
+func newCurlClient(urlStr string, opts curlOptions) (*http.Client, error) {
+	// Create HTTP transport
+	transport := &http.Transport{
+		// Set TLS client configuration
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: opts.insecure, // Skip certificate verification if insecure mode is enabled
+		},
+		// In raw mode the body is handed over as it came off the wire
+		DisableCompression: opts.raw,
+		// Bound the wait for "100 Continue" before the body is sent anyway
+		ExpectContinueTimeout: opts.expect100,
+	}
+
+	// A pinned key takes the place of the CA chain, so self-signed servers can still be
+	// verified; the pin alone decides whether the server is trusted
+	if len(opts.pinnedPubKeys) > 0 {
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		transport.TLSClientConfig.VerifyPeerCertificate = verifyPinnedPubKey(opts.pinnedPubKeys)
+	}
+
+	// Pin the protocol. The custom TLS configuration already keeps Go from trying HTTP/2
+	// on its own; ALPN then tells the server which version is acceptable.
+	switch opts.httpVersion {
+	case "1.1":
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case "2":
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL: %v", err)
+		}
+		if u.Scheme != "https" {
+			return nil, fmt.Errorf("--http-version 2 needs an https:// URL (HTTP/2 without TLS is not supported)")
+		}
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	// Send every request over the Unix socket, whatever host the URL names
+	if opts.unixSocket != "" {
+		dialer := &net.Dialer{}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", opts.unixSocket)
+		}
+	}
+
+	// Connect from the --interface address. Only server addresses of the same IP family
+	// are tried, so a dual-stack host is reached over whichever family the source has.
+	if opts.source != "" {
+		dialer := &net.Dialer{LocalAddr: sourceAddr("tcp", opts.source)}
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, fmt.Errorf("connecting from %s: %w", opts.source, err)
+			}
+			return conn, nil
+		}
+	}
+
+	// If a proxy is specified, set the proxy
+	if opts.proxy != "" {
+		proxyURL, err := url.Parse(opts.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Create HTTP client with the custom transport
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.timeout,
+	}
+	return client, nil
+}
+
+// defaultRetryStatuses are the responses retried by --retry without --retry-on-status:
+// request timeouts, rate limiting and server errors
+func defaultRetryStatuses(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// doWithRetry sends the request, repeating it up to opts.retries more times after a
+// transport error or a retryable status, waiting opts.retryDelay between attempts
+func doWithRetry(client *http.Client, req *http.Request, opts curlOptions) (*http.Response, error) {
+	// The client reads GetBody again to resend the body after a 307 or 308 redirect, so
+	// throttle that copy too; the original is put back for the HAR file
+	getBody := req.GetBody
+	if opts.rateLimit > 0 && getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newRateLimitedReadCloser(body, opts.rateLimit), nil
+		}
+		defer func() { req.GetBody = getBody }()
+	}
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		// Every attempt needs a fresh body
+		if attempt > 0 && getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %v", err)
+			}
+			req.Body = body
+		}
+
+		// Throttle the request body upload when a rate limit is set
+		if opts.rateLimit > 0 && req.Body != nil {
+			req.Body = newRateLimitedReadCloser(req.Body, opts.rateLimit)
+		}
+
+		resp, err := client.Do(req)
+
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case len(opts.retryStatuses) > 0 && opts.retryStatuses[resp.StatusCode]:
+			reason = "HTTP status " + resp.Status
+		case len(opts.retryStatuses) == 0 && defaultRetryStatuses(resp.StatusCode):
+			reason = "HTTP status " + resp.Status
+		default:
+			return resp, nil
+		}
+
+		if attempt >= opts.retries {
+			return resp, err
+		}
+
+		// A rate-limited or unavailable server may say when to come back
+		delay, source := opts.retryDelay, ""
+		if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				delay, source = retryAfter, " (Retry-After)"
+			}
+		}
+
+		// Don't wait past --retry-max-time; once it is used up, the last outcome stands
+		if opts.retryMaxTime > 0 {
+			remaining := opts.retryMaxTime - time.Since(start)
+			if remaining <= 0 {
+				return resp, err
+			}
+			delay = min(delay, remaining)
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s. Will retry in %s%s. %d retries left.\n",
+			reason, delay.Round(time.Millisecond), source, opts.retries-attempt)
+		time.Sleep(delay)
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, given either as a number of seconds or
+// as an HTTP date, into the time to wait from now
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
+
+// parseStatusList parses a comma-separated list of HTTP status codes such as "502,503,504"
+func parseStatusList(list []string) (map[int]bool, error) {
+	statuses := make(map[int]bool, len(list))
+	for _, item := range list {
+		code, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status %q", item)
+		}
+		statuses[code] = true
+	}
+	return statuses, nil
+}
+
+// traceWriter is the --trace-ascii file. All transfers write to it, and each section is
+// written whole, so the sections of parallel transfers don't mix.
+type traceWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// section writes a labelled block of wire data as one piece
+func (t *traceWriter) section(label string, dump []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	writeTraceSection(t.file, label, dump)
+}
+
+// writeTraceSection writes a timestamped, labelled block of wire data to the trace file
+func writeTraceSection(w io.Writer, label string, dump []byte) {
+	fmt.Fprintf(w, "== %s %s, %d bytes\n", time.Now().Format(time.RFC3339Nano), label, len(dump))
+	w.Write(dump)
+	fmt.Fprintln(w)
+}
+
 // printTLSDetails prints TLS details from the response
 func printTLSDetails(tlsState *tls.ConnectionState) {
 	fmt.Println("----- TLS Information -----")
 	fmt.Printf("Version: %s\n", tlsVersionToString(tlsState.Version))
 	fmt.Printf("Cipher Suite: %s\n", tls.CipherSuiteName(tlsState.CipherSuite))
+	alpn := tlsState.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "(none)"
+	}
+	fmt.Printf("ALPN: %s\n", alpn)
 	fmt.Println("Server Certificates:")
 	for _, cert := range tlsState.PeerCertificates {
 		fmt.Printf("  Subject: %s\n", cert.Subject)
 		fmt.Printf("  Issuer: %s\n", cert.Issuer)
 		fmt.Printf("  Valid From: %s\n", cert.NotBefore.Format(time.RFC3339))
-		fmt.Printf("  Valid Until: %s\n", cert.NotAfter.Format(time.RFC3339))
+		fmt.Printf("  Valid Until: %s (%s)\n", cert.NotAfter.Format(time.RFC3339), describeExpiry(cert.NotAfter, time.Now()))
 	}
 	fmt.Println("----------------------------")
 }
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// certExpiredExitCode is curl's exit code for a server certificate that can't be
+// trusted, used with --fail when the certificate has expired
+const certExpiredExitCode = 60
+
+// parseExpiryWindow parses the --cert-expiry-warn window, which is a number of days
+// ("14d") or a Go duration ("72h")
+func parseExpiryWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid window %q (use e.g. 14d or 72h)", s)
+	}
+	return d, nil
+}
+
+// describeExpiry tells how long a certificate valid until notAfter has left, or how
+// long ago it expired, in days once it is more than a day
+func describeExpiry(notAfter, now time.Time) string {
+	left := notAfter.Sub(now)
+	span := func(d time.Duration) string {
+		if d >= 48*time.Hour {
+			return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+		}
+		return d.Round(time.Minute).String()
+	}
+	if left < 0 {
+		return "expired " + span(-left) + " ago"
+	}
+	return "expires in " + span(left)
+}
+
+// checkCertExpiry warns on stderr when host's leaf certificate expires within
+// the window. An expired certificate, which only gets this far with -k, is an error
+// under --fail.
+func checkCertExpiry(tlsState *tls.ConnectionState, host string, opts curlOptions) error {
+	if len(tlsState.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := tlsState.PeerCertificates[0]
+	now := time.Now()
+	left := leaf.NotAfter.Sub(now)
+	if left >= 0 && left >= opts.certExpiryWarn {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Warning: the certificate for %s %s (%s)\n",
+		host, describeExpiry(leaf.NotAfter, now), leaf.NotAfter.Format(time.RFC3339))
+	if left < 0 && opts.fail {
+		return &exitError{code: certExpiredExitCode, err: fmt.Errorf("the server certificate has expired")}
+	}
+	return nil
+}
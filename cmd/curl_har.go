@@ -0,0 +1,293 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR (HTTP Archive) 1.2 structures, as imported by browser devtools. Only the fields
+// netro can fill in are included.
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// harTimings are in milliseconds; -1 marks a phase that didn't happen, such as the DNS
+// lookup and connect on a reused connection
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harRecorder collects the entries of every transfer for --har, which are written to
+// one file once all URLs have been fetched
+type harRecorder struct {
+	mu        sync.Mutex
+	path      string
+	sensitive bool // keep credential headers, cookies and URL passwords (--har-sensitive)
+	entries   []harEntry
+}
+
+// add records a transfer; it is safe to call from parallel transfers
+func (h *harRecorder) add(entry harEntry) {
+	if !h.sensitive {
+		redactHAREntry(&entry)
+	}
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.mu.Unlock()
+}
+
+// write saves the recorded entries, oldest first as the format asks
+func (h *harRecorder) write() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := append([]harEntry{}, h.entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartedDateTime < entries[j].StartedDateTime })
+
+	doc := struct {
+		Log harLog `json:"log"`
+	}{harLog{Version: "1.2", Creator: harCreator{Name: "netro", Version: Version}, Entries: entries}}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(h.path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write HAR file: %v", err)
+	}
+	return nil
+}
+
+// newHAREntry describes a finished transfer: the request that produced resp (the last
+// one when redirects were followed), its body if known, and the response body as read
+func newHAREntry(resp *http.Response, reqBody []byte, body []byte, info transferInfo, start time.Time) harEntry {
+	req := resp.Request
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Time:            harMillis(info.total),
+		ServerIPAddress: info.remoteIP,
+		Timings:         harPhaseTimings(info),
+	}
+
+	entry.Request = harRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    req.ContentLength,
+	}
+	for _, cookie := range req.Cookies() {
+		entry.Request.Cookies = append(entry.Request.Cookies, harNameValue{cookie.Name, cookie.Value})
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, value})
+		}
+	}
+	sort.SliceStable(entry.Request.QueryString, func(i, j int) bool {
+		return entry.Request.QueryString[i].Name < entry.Request.QueryString[j].Name
+	})
+	if reqBody != nil {
+		entry.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: string(reqBody)}
+	}
+
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harBodyContent(body, resp.Header.Get("Content-Type")),
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+	for _, cookie := range resp.Cookies() {
+		entry.Response.Cookies = append(entry.Response.Cookies, harNameValue{cookie.Name, cookie.Value})
+	}
+	return entry
+}
+
+// redactHAREntry masks the credentials of an entry, as browser devtools do when exporting
+// a HAR file meant to be shared: credential headers, cookie values and URL passwords
+func redactHAREntry(entry *harEntry) {
+	entry.Request.URL = redactURL(entry.Request.URL)
+	for _, headers := range [][]harNameValue{entry.Request.Headers, entry.Response.Headers} {
+		for i := range headers {
+			if secretHeaders[strings.ToLower(headers[i].Name)] {
+				headers[i].Value = redactedValue
+			}
+		}
+	}
+	for _, cookies := range [][]harNameValue{entry.Request.Cookies, entry.Response.Cookies} {
+		for i := range cookies {
+			cookies[i].Value = redactedValue
+		}
+	}
+}
+
+// harHeaders lists the headers sorted by name, one entry per value
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, harNameValue{name, value})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// harBodyContent stores a body as text, or base64-encoded when it isn't valid UTF-8
+// such as an image or a body left compressed by --raw
+func harBodyContent(body []byte, contentType string) harContent {
+	content := harContent{Size: int64(len(body)), MimeType: contentType}
+	if len(body) == 0 {
+		return content
+	}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+// harPhaseTimings splits the transfer's cumulative timestamps into the HAR phases. The
+// TLS handshake is part of connect, as the format defines, and sending isn't timed
+// separately, so it is counted as waiting.
+func harPhaseTimings(info transferInfo) harTimings {
+	timings := harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	ready := time.Duration(0)
+	if info.nameLookup > 0 {
+		timings.DNS = harMillis(info.nameLookup)
+		ready = info.nameLookup
+	}
+	if info.connect > 0 {
+		ready = max(info.connect, info.appConnect)
+		timings.Connect = harMillis(ready - info.nameLookup)
+		if info.appConnect > 0 {
+			timings.SSL = harMillis(info.appConnect - info.connect)
+		}
+	}
+	if info.startTransfer > 0 {
+		timings.Wait = harMillis(info.startTransfer - ready)
+		timings.Receive = harMillis(info.total - info.startTransfer)
+	} else {
+		timings.Wait = harMillis(info.total - ready)
+	}
+	return timings
+}
+
+// harMillis converts a duration to the fractional milliseconds HAR uses
+func harMillis(d time.Duration) float64 {
+	if d < 0 {
+		d = 0
+	}
+	return float64(d.Microseconds()) / 1000
+}
+
+// harRequestBody returns a copy of the body sent with sent, the request that produced the
+// response, for the HAR file. It is read from req, the original request, whose body isn't
+// throttled by --limit-rate. Streamed uploads aren't read into memory and return nil.
+func harRequestBody(sent, req *http.Request, opts curlOptions) ([]byte, error) {
+	if sent.GetBody == nil || req.GetBody == nil || opts.uploadFile != "" {
+		return nil, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeHAR writes the --har file, if one was asked for
+func writeHAR(opts curlOptions) error {
+	if opts.har == nil {
+		return nil
+	}
+	return opts.har.write()
+}
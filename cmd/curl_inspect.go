@@ -0,0 +1,135 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// inspectOrigin is the Origin sent with the CORS preflight unless -H sets one
+const inspectOrigin = "https://example.org"
+
+// inspectRow is a line of the --inspect table: a label and the header it is read from
+type inspectRow struct {
+	label  string
+	header string
+}
+
+// inspectSections group the headers summarized by --inspect. CORS headers come from the
+// OPTIONS response, the others from the HEAD response.
+var inspectSections = []struct {
+	title     string
+	preflight bool
+	rows      []inspectRow
+}{
+	{"CORS", true, []inspectRow{
+		{"Allow-Origin", "Access-Control-Allow-Origin"},
+		{"Allow-Methods", "Access-Control-Allow-Methods"},
+		{"Allow-Headers", "Access-Control-Allow-Headers"},
+		{"Allow-Credentials", "Access-Control-Allow-Credentials"},
+		{"Max-Age", "Access-Control-Max-Age"},
+	}},
+	{"Content", false, []inspectRow{
+		{"Content-Type", "Content-Type"},
+		{"Content-Length", "Content-Length"},
+		{"Content-Encoding", "Content-Encoding"},
+		{"Vary", "Vary"},
+	}},
+	{"Caching", false, []inspectRow{
+		{"Cache-Control", "Cache-Control"},
+		{"ETag", "ETag"},
+		{"Last-Modified", "Last-Modified"},
+		{"Expires", "Expires"},
+		{"Age", "Age"},
+	}},
+	{"Security", false, []inspectRow{
+		{"HSTS", "Strict-Transport-Security"},
+		{"CSP", "Content-Security-Policy"},
+		{"X-Content-Type-Options", "X-Content-Type-Options"},
+		{"X-Frame-Options", "X-Frame-Options"},
+		{"Referrer-Policy", "Referrer-Policy"},
+	}},
+}
+
+// inspectEndpoint sends a CORS preflight (OPTIONS) and a HEAD request to the URL and
+// prints a table of what the endpoint allows and how its responses are cached and secured
+func inspectEndpoint(urlStr string, opts curlOptions) error {
+	client, err := newCurlClient(urlStr, opts)
+	if err != nil {
+		return err
+	}
+
+	preflight, err := inspectRequest(client, http.MethodOptions, urlStr, opts)
+	if err != nil {
+		return err
+	}
+	head, err := inspectRequest(client, http.MethodHead, urlStr, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Endpoint: %s\n", urlStr)
+	fmt.Printf("  %-24s %s\n", "OPTIONS", preflight.Status)
+	fmt.Printf("  %-24s %s\n", "HEAD", head.Status)
+	fmt.Printf("  %-24s %s\n", "Allowed methods", headerOrDash(preflight.Header, "Allow"))
+
+	for _, section := range inspectSections {
+		resp := head
+		if section.preflight {
+			resp = preflight
+		}
+		fmt.Println(section.title)
+		for _, row := range section.rows {
+			value := headerOrDash(resp.Header, row.header)
+			// A policy is judged by its presence; the full value is rarely readable
+			if row.header == "Content-Security-Policy" && value != "-" {
+				value = fmt.Sprintf("present (%d bytes)", len(value))
+			}
+			if section.title == "Security" && value == "-" {
+				value = "missing"
+			}
+			fmt.Printf("  %-24s %s\n", row.label, value)
+		}
+	}
+	return nil
+}
+
+// inspectRequest sends a bodiless request with the -H headers. The OPTIONS request is a
+// CORS preflight, so it carries an Origin and the method the caller asked about.
+func inspectRequest(client *http.Client, method, urlStr string, opts curlOptions) (*http.Response, error) {
+	req, err := http.NewRequest(method, urlStr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if err := setRequestHeaders(req, opts); err != nil {
+		return nil, err
+	}
+
+	if method == http.MethodOptions {
+		if req.Header.Get("Origin") == "" {
+			req.Header.Set("Origin", inspectOrigin)
+		}
+		if req.Header.Get("Access-Control-Request-Method") == "" {
+			req.Header.Set("Access-Control-Request-Method", opts.method)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %v", method, err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// headerOrDash returns all values of a header joined by ", ", or "-" when it is absent
+func headerOrDash(header http.Header, name string) string {
+	values := header.Values(name)
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ", ")
+}
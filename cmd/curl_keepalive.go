@@ -0,0 +1,94 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// probeKeepAlive sends count sequential requests to the URL with one client, so that its
+// transport can keep the connection open between them, and reports for each request
+// whether it went out on a new or a reused connection and how long it took
+func probeKeepAlive(urlStr string, opts curlOptions, count int) error {
+	if count < 1 {
+		return fmt.Errorf("--keepalive-probe needs at least 1 request")
+	}
+	client, err := newCurlClient(urlStr, opts)
+	if err != nil {
+		return err
+	}
+
+	var reused int
+	var newTotal, reusedTotal time.Duration
+	var closeHeader bool
+	for i := 1; i <= count; i++ {
+		var body io.Reader
+		if opts.data != "" {
+			body = bytes.NewBufferString(opts.data)
+		}
+		req, err := http.NewRequest(opts.method, urlStr, body)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		if err := setRequestHeaders(req, opts); err != nil {
+			return err
+		}
+		if err := setOAuth2Header(req, opts); err != nil {
+			return err
+		}
+
+		var conn httptrace.GotConnInfo
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { conn = info }}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("request %d failed: %v", i, err)
+		}
+		// The connection only goes back to the pool once the body has been read to the end
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		elapsed := time.Since(start)
+
+		how := "new connection"
+		if conn.Reused {
+			reused++
+			reusedTotal += elapsed
+			how = fmt.Sprintf("reused connection (idle %s)", conn.IdleTime.Round(time.Millisecond))
+		} else {
+			newTotal += elapsed
+		}
+		if resp.Close {
+			closeHeader = true
+		}
+		remote := "-"
+		if conn.Conn != nil {
+			remote = conn.Conn.RemoteAddr().String()
+		}
+		fmt.Printf("#%-3d %-8s %s %s, %s  %.3f ms\n", i, resp.Proto, resp.Status, remote, how,
+			float64(elapsed.Microseconds())/1000)
+	}
+
+	fresh := count - reused
+	fmt.Printf("\n%d requests: %d on new connections, %d on reused connections\n", count, fresh, reused)
+	if fresh > 0 && reused > 0 {
+		fmt.Printf("Average time: %.3f ms new, %.3f ms reused\n",
+			float64((newTotal/time.Duration(fresh)).Microseconds())/1000,
+			float64((reusedTotal/time.Duration(reused)).Microseconds())/1000)
+	}
+	if count > 1 && reused == 0 {
+		if closeHeader {
+			fmt.Println("Keep-alive is not working: the server (or a proxy) asked to close the connection after each response.")
+		} else {
+			fmt.Println("Keep-alive is not working: no connection was reused.")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauth2Config holds the client credentials for --oauth2
+type oauth2Config struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+}
+
+// oauth2Token is an access token and the time it stops being usable
+type oauth2Token struct {
+	accessToken string
+	expires     time.Time // zero if the server did not say
+}
+
+// oauth2TokenMargin is how long before its expiry a token is replaced, so that it does
+// not run out while a request is in flight
+const oauth2TokenMargin = 30 * time.Second
+
+// oauth2Tokens caches access tokens for the lifetime of the process, so that several
+// URLs, parallel transfers and retries share one grant
+var oauth2Tokens = struct {
+	sync.Mutex
+	tokens map[oauth2Config]oauth2Token
+}{tokens: make(map[oauth2Config]oauth2Token)}
+
+// oauth2AccessToken returns a cached access token for the credentials, or obtains a new
+// one when there is none or it is about to expire
+func oauth2AccessToken(cfg oauth2Config, opts curlOptions) (string, error) {
+	oauth2Tokens.Lock()
+	defer oauth2Tokens.Unlock()
+
+	if token, ok := oauth2Tokens.tokens[cfg]; ok {
+		if token.expires.IsZero() || time.Now().Add(oauth2TokenMargin).Before(token.expires) {
+			return token.accessToken, nil
+		}
+	}
+
+	token, err := fetchOAuth2Token(cfg, opts)
+	if err != nil {
+		return "", err
+	}
+	oauth2Tokens.tokens[cfg] = token
+	return token.accessToken, nil
+}
+
+// setOAuth2Header fetches (or reuses) the --oauth2 access token and sends it with req,
+// unless -H already supplies the credentials
+func setOAuth2Header(req *http.Request, opts curlOptions) error {
+	if opts.oauth2 == nil || req.Header.Get("Authorization") != "" {
+		return nil
+	}
+	token, err := oauth2AccessToken(*opts.oauth2, opts)
+	if err != nil {
+		return fmt.Errorf("OAuth2: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// oauth2TokenResponse is the JSON answer of a token endpoint (RFC 6749 sections 5.1, 5.2)
+type oauth2TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// fetchOAuth2Token performs a client-credentials grant against the token endpoint,
+// authenticating with HTTP Basic as RFC 6749 recommends
+func fetchOAuth2Token(cfg oauth2Config, opts curlOptions) (oauth2Token, error) {
+	// The token endpoint is a different server: keep the TLS and proxy settings but
+	// don't route it through the Unix socket or pin the protocol or key meant for the API
+	tokenOpts := opts
+	tokenOpts.unixSocket = ""
+	tokenOpts.httpVersion = ""
+	tokenOpts.pinnedPubKeys = nil
+	client, err := newCurlClient(cfg.tokenURL, tokenOpts)
+	if err != nil {
+		return oauth2Token{}, err
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest("POST", cfg.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("invalid token URL: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(cfg.clientID), url.QueryEscape(cfg.clientSecret))
+
+	requested := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return oauth2Token{}, fmt.Errorf("failed to read token response: %v", err)
+	}
+	var parsed oauth2TokenResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return oauth2Token{}, fmt.Errorf("token endpoint returned %s with a non-JSON body", resp.Status)
+	}
+	if parsed.Error != "" {
+		if parsed.ErrorDescription != "" {
+			return oauth2Token{}, fmt.Errorf("token endpoint refused the grant: %s (%s)", parsed.Error, parsed.ErrorDescription)
+		}
+		return oauth2Token{}, fmt.Errorf("token endpoint refused the grant: %s", parsed.Error)
+	}
+	if resp.StatusCode != http.StatusOK || parsed.AccessToken == "" {
+		return oauth2Token{}, fmt.Errorf("token endpoint returned %s without an access token", resp.Status)
+	}
+	if parsed.TokenType != "" && !strings.EqualFold(parsed.TokenType, "bearer") {
+		return oauth2Token{}, fmt.Errorf("unsupported token type %q (only Bearer tokens can be sent)", parsed.TokenType)
+	}
+
+	token := oauth2Token{accessToken: parsed.AccessToken}
+	if parsed.ExpiresIn > 0 {
+		token.expires = requested.Add(time.Duration(parsed.ExpiresIn) * time.Second)
+	}
+	if opts.verbose {
+		if token.expires.IsZero() {
+			fmt.Printf("OAuth2: obtained an access token from %s\n", cfg.tokenURL)
+		} else {
+			fmt.Printf("OAuth2: obtained an access token from %s (expires in %ds)\n", cfg.tokenURL, parsed.ExpiresIn)
+		}
+	}
+	return token, nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// parsePinnedPubKeys parses a --pinnedpubkey value: one or more "sha256//<base64>"
+// hashes of a subject public key info, separated by ";" as in curl
+func parsePinnedPubKeys(spec string) ([]string, error) {
+	var pins []string
+	for _, pin := range strings.Split(spec, ";") {
+		pin = strings.TrimSpace(pin)
+		hash, ok := strings.CutPrefix(pin, "sha256//")
+		if !ok {
+			return nil, fmt.Errorf("unsupported pin %q (use sha256//<base64 hash>)", pin)
+		}
+		raw, err := base64.StdEncoding.DecodeString(hash)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 hash in pin %q", pin)
+		}
+		pins = append(pins, hash)
+	}
+	return pins, nil
+}
+
+// publicKeyPin returns the curl-style pin of a certificate's public key: the base64
+// SHA-256 hash of its DER-encoded subject public key info
+func publicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPinnedPubKey returns a tls.Config.VerifyPeerCertificate callback accepting the
+// server only if its leaf certificate's public key matches one of the pins
+func verifyPinnedPubKey(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server sent no certificate to check against --pinnedpubkey")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse server certificate: %v", err)
+		}
+		got := publicKeyPin(cert)
+		for _, pin := range pins {
+			if pin == got {
+				return nil
+			}
+		}
+		return fmt.Errorf("server public key sha256//%s does not match --pinnedpubkey", got)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"io"
+	"mime"
+	"strings"
+)
+
+// prettyBody reformats a response body for reading according to its Content-Type:
+// JSON and XML are indented and HTML is rendered as plain text. Other types, and
+// bodies that fail to parse, are returned unchanged.
+func prettyBody(body []byte, contentType string) []byte {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return body
+	}
+	var pretty []byte
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var buf bytes.Buffer
+		if err = json.Indent(&buf, body, "", "  "); err == nil {
+			pretty = buf.Bytes()
+		}
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		pretty, err = indentXML(body)
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		pretty = renderHTMLText(body)
+	default:
+		return body
+	}
+	if err != nil {
+		return body
+	}
+	return pretty
+}
+
+// indentXML re-encodes an XML document with one element per line, indented by nesting
+func indentXML(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	for {
+		// Raw tokens keep namespace prefixes as written instead of resolving them
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			// The whitespace between elements is replaced by the encoder's indentation
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		case xml.StartElement:
+			t.Name = prefixedName(t.Name)
+			attrs := make([]xml.Attr, len(t.Attr))
+			for i, attr := range t.Attr {
+				attrs[i] = xml.Attr{Name: prefixedName(attr.Name), Value: attr.Value}
+			}
+			t.Attr = attrs
+			tok = t
+		case xml.EndElement:
+			t.Name = prefixedName(t.Name)
+			tok = t
+		}
+		if err := enc.EncodeToken(tok); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// prefixedName folds a raw token's namespace prefix back into its local name, so that
+// the encoder writes "soap:Body" rather than declaring "soap" as a namespace
+func prefixedName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+// htmlBlockTags start a new line when rendering HTML as text
+var htmlBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"title": true, "tr": true, "ul": true,
+}
+
+// renderHTMLText strips the tags from an HTML page, leaving its text with a line per
+// block element, list items marked with "- " and the contents of scripts, styles and
+// comments removed
+func renderHTMLText(body []byte) []byte {
+	var out strings.Builder
+	s := string(body)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			i = len(s)
+		}
+		out.WriteString(collapseSpace(html.UnescapeString(s[:i])))
+		s = s[i:]
+		if s == "" {
+			break
+		}
+
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+3:]
+			continue
+		}
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			break
+		}
+		tag := s[1:end]
+		s = s[end+1:]
+		closing := strings.HasPrefix(tag, "/")
+		name := htmlTagName(tag)
+
+		switch {
+		case !closing && (name == "script" || name == "style"):
+			// Drop everything up to the end of the element
+			i := strings.Index(strings.ToLower(s), "</"+name)
+			if i < 0 {
+				s = ""
+				break
+			}
+			s = s[i:]
+			if gt := strings.IndexByte(s, '>'); gt >= 0 {
+				s = s[gt+1:]
+			}
+		case name == "li":
+			// The next item, or the end of the list, ends the line
+			if !closing {
+				out.WriteString("\n- ")
+			}
+		case htmlBlockTags[name]:
+			out.WriteByte('\n')
+		}
+	}
+
+	// Trim the lines and keep at most one blank line between paragraphs
+	var lines []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return []byte(strings.TrimSpace(strings.Join(lines, "\n")))
+}
+
+// htmlTagName returns the lower-cased element name of the inside of a tag, such as
+// "a" for `a href="/"` or "br" for "br/"
+func htmlTagName(tag string) string {
+	tag = strings.TrimPrefix(tag, "/")
+	if i := strings.IndexAny(tag, " \t\r\n/"); i >= 0 {
+		tag = tag[:i]
+	}
+	return strings.ToLower(tag)
+}
+
+// collapseSpace replaces each run of white space in HTML text with a single space
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
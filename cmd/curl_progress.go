@@ -0,0 +1,83 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn
+const progressInterval = 200 * time.Millisecond
+
+// progressReader reports the progress of a download on a single, redrawn stderr line
+type progressReader struct {
+	r        io.Reader
+	total    int64 // expected size from Content-Length, or -1 when unknown
+	read     int64
+	start    time.Time
+	lastDraw time.Time
+}
+
+// newProgressReader wraps r to show progress towards total bytes (-1 if unknown)
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	now := time.Now()
+	return &progressReader{r: r, total: total, start: now, lastDraw: now}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if time.Since(p.lastDraw) >= progressInterval {
+		p.draw()
+	}
+	return n, err
+}
+
+// finish draws the final state and ends the progress line
+func (p *progressReader) finish() {
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+// draw rewrites the progress line: percentage, bytes, rate and ETA when the size is
+// known, otherwise only the bytes transferred and the rate
+func (p *progressReader) draw() {
+	p.lastDraw = time.Now()
+	elapsed := time.Since(p.start).Seconds()
+	rate := formatRate(uint64(p.read), elapsed)
+
+	// Pad with spaces to clear what is left of a longer previous line
+	if p.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%10s  %12s   ", formatSize(p.read), rate)
+		return
+	}
+
+	percent := 100 * float64(p.read) / float64(p.total)
+	eta := "--"
+	if p.read > 0 && p.read < p.total {
+		remaining := time.Duration(float64(p.total-p.read) / float64(p.read) * elapsed * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	} else if p.read >= p.total {
+		eta = "0s"
+	}
+	fmt.Fprintf(os.Stderr, "\r%5.1f%%  %10s / %-10s  %12s  ETA %-8s", percent, formatSize(p.read), formatSize(p.total), rate, eta)
+}
+
+// formatSize renders a byte count in human-readable units
+func formatSize(bytes int64) string {
+	size := float64(bytes)
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// readRequestFile parses a raw HTTP/1.x request, as captured by a proxy or another tool,
+// and turns it into a request to urlStr. The method, path, query, headers and body come
+// from the file; the scheme and host come from the URL, and the connection headers are
+// left to the transport.
+func readRequestFile(path, urlStr string) (*http.Request, error) {
+	target, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q: --request-file needs a scheme and host", urlStr)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open request file: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	captured, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request file %s: %v", path, err)
+	}
+	body, err := io.ReadAll(captured.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body from request file: %v", err)
+	}
+
+	// Without Content-Length or chunked encoding a request has no body as far as HTTP is
+	// concerned, but a hand-edited capture usually means the rest of the file
+	if len(body) == 0 && captured.ContentLength <= 0 && len(captured.TransferEncoding) == 0 {
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body from request file: %v", err)
+		}
+	}
+
+	// Keep the captured path and query, whether the request line held just the path or
+	// an absolute URL as sent to a proxy
+	u := *target
+	u.Path = captured.URL.Path
+	u.RawPath = captured.URL.RawPath
+	u.RawQuery = captured.URL.RawQuery
+
+	req, err := http.NewRequest(captured.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header = captured.Header
+	for _, name := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "Content-Length", "Transfer-Encoding"} {
+		req.Header.Del(name)
+	}
+	if len(body) == 0 {
+		// Don't announce an empty body on requests that had none
+		req.Body = http.NoBody
+		req.ContentLength = 0
+	}
+	return req, nil
+}
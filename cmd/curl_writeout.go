@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transferInfo is what --write-out can report about a finished transfer
+type transferInfo struct {
+	httpCode      int
+	httpVersion   string
+	contentType   string
+	urlEffective  string
+	remoteIP      string
+	remotePort    string
+	sizeDownload  int64
+	nameLookup    time.Duration // the durations are measured from the start of the request
+	connect       time.Duration
+	appConnect    time.Duration
+	startTransfer time.Duration
+	total         time.Duration
+}
+
+// writeOutVariables maps the --write-out variables, named as in curl, to their values
+var writeOutVariables = map[string]func(transferInfo) string{
+	"http_code":          func(t transferInfo) string { return fmt.Sprintf("%03d", t.httpCode) },
+	"http_version":       func(t transferInfo) string { return t.httpVersion },
+	"content_type":       func(t transferInfo) string { return t.contentType },
+	"url_effective":      func(t transferInfo) string { return t.urlEffective },
+	"remote_ip":          func(t transferInfo) string { return t.remoteIP },
+	"remote_port":        func(t transferInfo) string { return t.remotePort },
+	"size_download":      func(t transferInfo) string { return strconv.FormatInt(t.sizeDownload, 10) },
+	"time_namelookup":    func(t transferInfo) string { return writeOutSeconds(t.nameLookup) },
+	"time_connect":       func(t transferInfo) string { return writeOutSeconds(t.connect) },
+	"time_appconnect":    func(t transferInfo) string { return writeOutSeconds(t.appConnect) },
+	"time_starttransfer": func(t transferInfo) string { return writeOutSeconds(t.startTransfer) },
+	"time_total":         func(t transferInfo) string { return writeOutSeconds(t.total) },
+}
+
+// writeOutSeconds formats a duration in seconds with microsecond precision, as curl does
+func writeOutSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.6f", d.Seconds())
+}
+
+// writeOutVariableNames returns the names of the --write-out variables, sorted
+func writeOutVariableNames() []string {
+	names := make([]string, 0, len(writeOutVariables))
+	for name := range writeOutVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandWriteOut fills a --write-out template: %{name} is replaced by the variable's
+// value, %% by a percent sign, and the escapes \n, \r, \t and \\ by the characters
+// they stand for. Unknown variables are an error.
+func expandWriteOut(format string, info transferInfo) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '%' && strings.HasPrefix(format[i:], "%%"):
+			out.WriteByte('%')
+			i++
+		case c == '%' && strings.HasPrefix(format[i:], "%{"):
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable in --write-out: %q", format[i:])
+			}
+			name := format[i+2 : i+end]
+			value, ok := writeOutVariables[name]
+			if !ok {
+				return "", fmt.Errorf("unknown --write-out variable %q (use %s)", name, strings.Join(writeOutVariableNames(), ", "))
+			}
+			out.WriteString(value(info))
+			i += end
+		case c == '\\' && i+1 < len(format):
+			switch format[i+1] {
+			case 'n':
+				out.WriteByte('\n')
+			case 'r':
+				out.WriteByte('\r')
+			case 't':
+				out.WriteByte('\t')
+			case '\\':
+				out.WriteByte('\\')
+			default:
+				out.WriteByte(c)
+				continue
+			}
+			i++
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+// transferTimer records when the phases of a request complete and where the request
+// went, for --write-out, --show-ip and -v. With
+// retries and redirects, the last connection made is the one reported.
+type transferTimer struct {
+	mu    sync.Mutex
+	start time.Time
+	info  transferInfo
+}
+
+// trace returns the client trace hooks that fill in the timer
+func (t *transferTimer) trace() *httptrace.ClientTrace {
+	since := func(d *time.Duration) {
+		t.mu.Lock()
+		*d = time.Since(t.start)
+		t.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSDone:     func(httptrace.DNSDoneInfo) { since(&t.info.nameLookup) },
+		ConnectDone: func(_, _ string, err error) { since(&t.info.connect) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			since(&t.info.appConnect)
+		},
+		GotConn: func(conn httptrace.GotConnInfo) {
+			host, port, err := net.SplitHostPort(conn.Conn.RemoteAddr().String())
+			if err != nil {
+				return // a Unix socket has no IP address
+			}
+			t.mu.Lock()
+			t.info.remoteIP, t.info.remotePort = host, port
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() { since(&t.info.startTransfer) },
+	}
+}
+
+// remote returns the IP address and port of the connection the response came over,
+// empty for Unix sockets or before a connection was made
+func (t *transferTimer) remote() (string, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.info.remoteIP, t.info.remotePort
+}
+
+// finish completes the transfer's details from the response and its body
+func (t *transferTimer) finish(resp *http.Response, size int64) transferInfo {
+	t.mu.Lock()
+	info := t.info
+	t.mu.Unlock()
+
+	info.total = time.Since(t.start)
+	info.httpCode = resp.StatusCode
+	info.httpVersion = fmt.Sprintf("%d", resp.ProtoMajor)
+	if resp.ProtoMajor == 1 {
+		info.httpVersion = fmt.Sprintf("%d.%d", resp.ProtoMajor, resp.ProtoMinor)
+	}
+	info.contentType = resp.Header.Get("Content-Type")
+	info.urlEffective = resp.Request.URL.String()
+	info.sizeDownload = size
+	return info
+}
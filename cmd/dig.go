@@ -4,10 +4,17 @@ Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/miekg/dns"
 	"gopkg.in/yaml.v2"
 
 	"github.com/spf13/cobra"
@@ -15,15 +22,240 @@ import (
 
 // digCmd represents the dig command
 var digCmd = &cobra.Command{
-	Use:   "dig [domain]",
+	Use:   "dig [domain] [@server]",
 	Short: "Performs DNS lookups for the specified domain",
 	Long: `Netro's dig command performs DNS lookups for the specified domain, 
-similar to the 'dig' command in Unix. It supports querying for A, AAAA, MX, CNAME records, and prints the output in YAML format.`,
-	Args: cobra.ExactArgs(1),
+similar to the 'dig' command in Unix. It supports querying for A, AAAA, MX, CNAME records, and prints the output in YAML format.
+Use --type to query a single record type directly on the wire, including newer types such as HTTPS and SVCB.
+Use --compare with two resolvers to diff their answers; the command exits non-zero when they differ.
+Use --output zone to print the records in zone-file (BIND) presentation format, with TTLs.
+Use --chain to print the CNAME hops and the final addresses on one line, e.g. www.example.com → cdn.example.net → 1.2.3.4.
+Name a nameserver as @server (e.g. @8.8.8.8 or @[2001:db8::1]:5353) to query it instead of the system resolver.
+Use --class to query another class, e.g. "netro dig --class CH --type TXT version.bind @ns1.example.com".
+Queries sent on the wire (--type, --output zone) report the response size and EDNS parameters
+like dig's ";; MSG SIZE rcvd:" line; --bufsize sets the advertised EDNS buffer (0 disables EDNS).
+The status field tells NOERROR, NXDOMAIN (no such name), NODATA (no records of that type), SERVFAIL and TIMEOUT apart.
+--type ANY asks for all records of a name, though many servers now answer it minimally (RFC 8482).
+--type AXFR requests a zone transfer over TCP, usually from @server, and prints the zone in zone-file
+format; most servers only allow transfers to their secondaries.
+--security-check needs no domain: it has the resolver look up DNS-OARC's porttest and txidtest names,
+whose answers rate how random the resolver's source ports and query IDs are (anti-spoofing).
+Internationalized names (e.g. bücher.example) are looked up in Punycode and shown in Unicode; --no-idn disables this.
+--benchmark sends --queries queries (type A unless --type is given) with up to --concurrency in flight
+and prints the queries per second, response codes, lost queries and latency percentiles, like dnsperf.
+--propagation asks Google, Cloudflare, Quad9 and OpenDNS (or the --resolvers given) for the record (type A
+unless --type is given) at once and prints each one's answer and TTL, marking those that differ from the
+most common answer; the command exits non-zero until all of them agree.
+--ecs 198.51.100.0/24 sends raw queries with an EDNS Client Subnet option, so a GeoDNS or CDN resolver
+answers as it would for clients in that subnet; the ";; CLIENT-SUBNET" line shows the scope it applies to.
+--source sends the queries from one of this host's addresses, e.g. to test a resolver's ACLs or
+split-horizon views from a multi-homed host: "netro dig example.com @10.0.0.53 --source 10.0.0.2".
+--cache keeps raw-query answers on disk and reuses them until their TTLs run out, marking the
+";; MSG SIZE" line "(cached)"; --no-cache overrides it and "netro dig cache clear" empties the cache.
+--snapshot-save base.yaml stores the answers (as printed, with -s or --type applied) and --snapshot-diff
+base.yaml queries again and lists the records removed (-) and added (+) in each record set, exiting
+non-zero if any changed; record order and TTLs are ignored. Giving both compares and then updates the snapshot.
+--expect-a 1.2.3.4 (and --expect-aaaa, --expect-cname, --expect-mx, --expect-ns, --expect-txt) checks
+that the record set contains the value and prints PASS or FAIL instead of the answers, exiting non-zero
+if any expectation fails, e.g. as a health check in cron or CI. Addresses are compared as IPs and names
+ignore case and the trailing dot; an MX value may be a host or "priority host". With --type, only that
+type is queried.`,
+	Args: cobra.RangeArgs(0, 2),
 	Run: func(cmd *cobra.Command, args []string) {
-		domain := args[0]
+		domain, server, err := parseDigArgs(args)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
 		simpleMode, _ := cmd.Flags().GetBool("s")
-		queryDNS(domain, simpleMode)
+		queryType, _ := cmd.Flags().GetString("type")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		compare, _ := cmd.Flags().GetStringSlice("compare")
+		output, _ := cmd.Flags().GetString("output")
+		chain, _ := cmd.Flags().GetBool("chain")
+		className, _ := cmd.Flags().GetString("class")
+		bufsize, _ := cmd.Flags().GetUint16("bufsize")
+		securityCheckMode, _ := cmd.Flags().GetBool("security-check")
+		useCache, _ := cmd.Flags().GetBool("cache")
+		noCache, _ := cmd.Flags().GetBool("no-cache")
+		noIDN, _ := cmd.Flags().GetBool("no-idn")
+		benchmark, _ := cmd.Flags().GetBool("benchmark")
+		queries, _ := cmd.Flags().GetInt("queries")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		source, _ := cmd.Flags().GetString("source")
+		propagation, _ := cmd.Flags().GetBool("propagation")
+		resolvers, _ := cmd.Flags().GetStringSlice("resolvers")
+		ecs, _ := cmd.Flags().GetString("ecs")
+		snapshotSave, _ := cmd.Flags().GetString("snapshot-save")
+		snapshotDiff, _ := cmd.Flags().GetString("snapshot-diff")
+		var expectations []dnsExpectation
+		for _, recordType := range expectTypes {
+			values, _ := cmd.Flags().GetStringArray("expect-" + strings.ToLower(recordType))
+			for _, value := range values {
+				expectations = append(expectations, dnsExpectation{recordType: recordType, value: value})
+			}
+		}
+
+		qclass, ok := dns.StringToClass[strings.ToUpper(className)]
+		if !ok {
+			fatalf("Error: unknown query class %q (use IN, CH or HS)", className)
+		}
+
+		// Outside the Internet class, records come from the raw-query resolver; by
+		// convention CHAOS and Hesiod data is published as TXT records
+		if qclass != dns.ClassINET && queryType == "" {
+			queryType = "TXT"
+		}
+
+		opts := digOptions{
+			simple:    simpleMode,
+			queryType: queryType,
+			timeout:   timeout,
+			server:    server,
+			class:     qclass,
+			bufsize:   bufsize,
+			noIDN:     noIDN,
+			expect:    expectations,
+		}
+		if ecs != "" {
+			if bufsize == 0 {
+				fatalf("Error: --ecs is an EDNS option and can't be used with --bufsize 0")
+			}
+			opts.ecs, err = parseClientSubnet(ecs)
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+		}
+		if source != "" {
+			opts.source, err = resolveBindAddress(source)
+			if err != nil {
+				fatalf("Error: invalid --source: %v", err)
+			}
+		}
+
+		// Audit the resolver itself rather than look up a domain
+		if securityCheckMode {
+			if err := securityCheck(opts); err != nil {
+				fatalf("Error running security check: %v", err)
+			}
+			return
+		}
+		if domain == "" {
+			fatalf("Error: no domain given")
+		}
+
+		// Names with non-ASCII characters are looked up in their Punycode form
+		if !noIDN {
+			domain, err = idnToASCII(domain)
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+		}
+
+		if output != "yaml" && output != "zone" {
+			fatalf("Error: unsupported output format %q (use yaml or zone)", output)
+		}
+
+		// Measure the resolver's throughput and latency instead of printing the records
+		if benchmark {
+			if err := benchmarkResolver(domain, opts, queries, concurrency); err != nil {
+				fatalf("Error benchmarking: %v", err)
+			}
+			return
+		}
+
+		// Only raw queries carry the TTLs that bound how long an answer may be reused;
+		// --no-cache wins so that a fresh answer can be forced from a --cache alias
+		if useCache && !noCache {
+			if queryType == "" && output != "zone" {
+				fatalf("Error: --cache works with --type or --output zone")
+			}
+			path, err := dnsCachePath()
+			if err == nil {
+				opts.cache, err = loadDNSCache(path)
+			}
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+		}
+
+		// Diff the answers of two resolvers instead of printing the records
+		if len(compare) > 0 {
+			same, err := compareResolvers(domain, compare, opts)
+			if err != nil {
+				fatalf("Error comparing resolvers: %v", err)
+			}
+			if !same {
+				exitCommand(1, errors.New("the resolvers' answers differ"))
+			}
+			return
+		}
+
+		// Ask the well-known public resolvers, or --resolvers, whether a change has arrived
+		if len(resolvers) > 0 && !propagation {
+			fatalf("Error: --resolvers requires --propagation")
+		}
+		if propagation {
+			list := publicResolvers
+			if len(resolvers) > 0 {
+				list = parseResolverList(resolvers)
+			}
+			same, err := checkPropagation(domain, list, opts)
+			if err != nil {
+				fatalf("Error checking propagation: %v", err)
+			}
+			if !same {
+				exitCommand(1, errors.New("the resolvers' answers differ"))
+			}
+			return
+		}
+
+		// Render the alias chain and its final addresses as a single line
+		if chain {
+			if err := printChain(domain, opts); err != nil {
+				fatalf("Error resolving %s: %v", domain, err)
+			}
+			return
+		}
+
+		// A zone transfer returns a whole zone, which is printed in zone-file format
+		if strings.EqualFold(queryType, "AXFR") {
+			if err := printTransfer(domain, opts); err != nil {
+				fatalf("Error transferring %s: %v", domain, err)
+			}
+			return
+		}
+
+		// Zone-file output needs TTLs, so it always goes through the raw-query resolver
+		if output == "zone" {
+			if len(expectations) > 0 {
+				fatalf("Error: the --expect-* flags work with the YAML output")
+			}
+			if err := printZone(domain, opts); err != nil {
+				fatalf("Error querying %s: %v", domain, err)
+			}
+			return
+		}
+
+		// The system's stub resolver can't send EDNS options
+		if opts.ecs != nil && queryType == "" {
+			fatalf("Error: --ecs needs a query sent on the wire; add --type (e.g. --type A) or --output zone")
+		}
+
+		// Record the answers, or compare them with those recorded earlier
+		if snapshotSave != "" || snapshotDiff != "" {
+			if output == "zone" {
+				fatalf("Error: --snapshot-save and --snapshot-diff work with the YAML output")
+			}
+			same, err := snapshotDNS(domain, opts, snapshotSave, snapshotDiff)
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+			if !same {
+				exitCommand(1, errors.New("the answers changed since the snapshot"))
+			}
+			return
+		}
+		queryDNS(domain, opts)
 	},
 }
 
@@ -31,17 +263,113 @@ similar to the 'dig' command in Unix. It supports querying for A, AAAA, MX, CNAM
 func init() {
 	rootCmd.AddCommand(digCmd)
 	digCmd.Flags().BoolP("s", "s", false, "Show only CNAME and A/AAAA IPs if available")
+	digCmd.Flags().String("type", "", "Query a single record type (e.g. A, MX, HTTPS, SVCB) with the raw-query resolver")
+	digCmd.Flags().StringSlice("compare", nil, "Compare the answers of two resolvers, e.g. 8.8.8.8,1.1.1.1")
+	digCmd.Flags().StringP("output", "o", "yaml", "Output format: yaml or zone (BIND zone-file format)")
+	digCmd.Flags().Bool("chain", false, "Print the CNAME chain and the final A/AAAA answers as one arrow-joined line")
+	digCmd.Flags().String("class", "IN", "Query class: IN, CH (CHAOS) or HS (Hesiod); classes other than IN default --type to TXT")
+	digCmd.Flags().Bool("security-check", false, "Rate the randomness of the resolver's source ports and query IDs using DNS-OARC's test names")
+	digCmd.Flags().Bool("cache", false, "Serve --type and --output zone answers from a local cache until their TTLs expire, marked \"(cached)\"")
+	digCmd.Flags().Bool("no-cache", false, "Always ask the server, overriding --cache")
+	digCmd.Flags().Bool("no-idn", false, "Don't convert internationalized domain names to Punycode for lookup, or back to Unicode for display")
+	digCmd.Flags().Bool("benchmark", false, "Send many queries for the domain to the resolver and report queries per second, latency percentiles and losses")
+	digCmd.Flags().Int("queries", 100, "Number of queries sent by --benchmark")
+	digCmd.Flags().Int("concurrency", 10, "Queries in flight at once with --benchmark")
+	for _, flag := range []string{"compare", "chain", "security-check", "cache", "s"} {
+		digCmd.MarkFlagsMutuallyExclusive("benchmark", flag)
+	}
+	digCmd.Flags().String("source", "", "Send queries from this local address (or the primary address of this interface)")
+	digCmd.Flags().Bool("propagation", false, "Ask well-known public resolvers (Google, Cloudflare, Quad9, OpenDNS) for the record and compare their answers")
+	digCmd.Flags().StringSlice("resolvers", nil, "Resolvers asked by --propagation instead of the built-in list, e.g. 8.8.8.8,1.1.1.1")
+	for _, flag := range []string{"compare", "chain", "security-check", "benchmark", "cache", "s"} {
+		digCmd.MarkFlagsMutuallyExclusive("propagation", flag)
+	}
+	digCmd.Flags().String("ecs", "", "Send an EDNS Client Subnet option for this subnet (e.g. 198.51.100.0/24) and show the scope of the answer")
+	digCmd.Flags().String("snapshot-save", "", "Save the answers to this YAML file, to compare with later using --snapshot-diff")
+	digCmd.Flags().String("snapshot-diff", "", "Query again and print what changed since the answers saved in this file; exits non-zero on any change")
+	for _, flag := range []string{"compare", "chain", "security-check", "benchmark", "propagation"} {
+		digCmd.MarkFlagsMutuallyExclusive("snapshot-save", flag)
+		digCmd.MarkFlagsMutuallyExclusive("snapshot-diff", flag)
+	}
+	for _, recordType := range expectTypes {
+		flag := "expect-" + strings.ToLower(recordType)
+		digCmd.Flags().StringArray(flag, nil, fmt.Sprintf("Exit 0 only if the %s records include this value, printing PASS or FAIL (can be used multiple times)", recordType))
+		for _, other := range []string{"compare", "chain", "security-check", "benchmark", "propagation", "snapshot-save", "snapshot-diff", "s"} {
+			digCmd.MarkFlagsMutuallyExclusive(flag, other)
+		}
+	}
+	digCmd.Flags().Uint16("bufsize", 1232, "EDNS UDP buffer size advertised by raw queries (0 sends queries without EDNS)")
+}
+
+// parseDigArgs splits the arguments into the domain, empty if none was given, and an
+// optional "@server", which is returned as host:port
+func parseDigArgs(args []string) (domain, server string, err error) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "@") {
+			if server != "" || len(arg) == 1 {
+				return "", "", fmt.Errorf("expected a single @server argument")
+			}
+			server = nameserverAddress(arg[1:])
+			continue
+		}
+		if domain != "" {
+			return "", "", fmt.Errorf("expected a single domain, got %q and %q", domain, arg)
+		}
+		domain = arg
+	}
+	return domain, server, nil
+}
+
+// digOptions holds the settings collected from the dig command's flags
+type digOptions struct {
+	simple    bool
+	queryType string
+	timeout   time.Duration    // bounds the whole lookup; 0 means no limit
+	server    string           // nameserver as host:port from "@server"; the system resolver if empty
+	class     uint16           // query class for the raw-query resolver
+	bufsize   uint16           // EDNS UDP buffer size for the raw-query resolver; 0 disables EDNS
+	cache     *dnsCache        // answer cache for --type and --output zone queries, if enabled
+	noIDN     bool             // send and show names as given, without Punycode conversion
+	source    string           // local IP address queries are sent from; chosen by the kernel if empty
+	ecs       *net.IPNet       // client subnet sent with raw queries as EDNS Client Subnet, if set
+	expect    []dnsExpectation // records the answers must contain; they are checked instead of printed
+}
+
+// resolver returns the stub resolver for the standard lookups, sending its queries to
+// the @server nameserver when one was given, from the --source address if set
+func (o digOptions) resolver() *net.Resolver {
+	if o.server == "" && o.source == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if o.server != "" {
+				address = o.server
+			}
+			d := net.Dialer{LocalAddr: sourceAddr(network, o.source)}
+			conn, err := d.DialContext(ctx, network, address)
+			if err != nil && o.source != "" {
+				return nil, sourceBindError(o.source, address, err)
+			}
+			return conn, err
+		},
+	}
 }
 
 // DNSResults is a struct to hold all DNS query results in a structured format
 type DNSResults struct {
-	Domain string     `yaml:"domain"`
-	A      []string   `yaml:"A,omitempty"`
-	AAAA   []string   `yaml:"AAAA,omitempty"`
-	CNAME  []string   `yaml:"CNAME,omitempty"` // Now supports multiple CNAMEs in the chain
-	MX     []MXRecord `yaml:"MX,omitempty"`
-	NS     []string   `yaml:"NS,omitempty"`
-	TXT    []string   `yaml:"TXT,omitempty"`
+	Domain string       `yaml:"domain"`
+	Status string       `yaml:"status,omitempty"` // NOERROR, NXDOMAIN, NODATA, SERVFAIL, TIMEOUT, ...
+	A      []string     `yaml:"A,omitempty"`
+	AAAA   []string     `yaml:"AAAA,omitempty"`
+	CNAME  []string     `yaml:"CNAME,omitempty"` // Now supports multiple CNAMEs in the chain
+	MX     []MXRecord   `yaml:"MX,omitempty"`
+	NS     []string     `yaml:"NS,omitempty"`
+	TXT    []string     `yaml:"TXT,omitempty"`
+	HTTPS  []SVCBRecord `yaml:"HTTPS,omitempty"`
+	SVCB   []SVCBRecord `yaml:"SVCB,omitempty"`
+	Other  []string     `yaml:"other,omitempty"` // Record types without a dedicated field, in presentation format
 }
 
 type MXRecord struct {
@@ -49,87 +377,526 @@ type MXRecord struct {
 	Priority uint16 `yaml:"priority"`
 }
 
+// SVCBRecord holds a decoded SVCB or HTTPS record
+type SVCBRecord struct {
+	Priority uint16            `yaml:"priority"`
+	Target   string            `yaml:"target"`
+	Params   map[string]string `yaml:"params,omitempty"`
+}
+
 // queryDNS performs DNS lookups and prints results in YAML, optionally with -s flag to show only CNAME and IPs
-func queryDNS(domain string, simpleMode bool) {
+func queryDNS(domain string, opts digOptions) {
+	results, err := lookupDNS(domain, opts)
+	if err != nil {
+		fatalf("Error querying %s records: %v", opts.queryType, err)
+	}
+
+	// Check the answers against --expect-<type> instead of printing them
+	if len(opts.expect) > 0 {
+		if !checkExpectations(results, opts.expect) {
+			exitCommand(1, errors.New("expected records not found"))
+		}
+		return
+	}
+
+	// Handle printing results
+	if opts.simple && opts.queryType == "" {
+		// Only show CNAME and A/AAAA records in YAML
+		printSimpleResults(results)
+	} else {
+		// Print all results in YAML format
+		printResults(results)
+	}
+}
+
+// lookupDNS performs the DNS lookups for domain and collects their answers. Only a
+// --type query can fail; the standard lookups report problems in the status instead.
+func lookupDNS(domain string, opts digOptions) (DNSResults, error) {
+	simpleMode := opts.simple
 	results := DNSResults{
 		Domain: domain,
 	}
 
-	// A Record Lookup (NAME HERE <EMAIL ADDRESS>IPv4)
-	aRecords, err := net.LookupIP(domain)
-	if err == nil {
-		for _, ip := range aRecords {
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	resolver := opts.resolver()
+
+	// A single record type goes straight to the raw-query resolver
+	if opts.queryType != "" {
+		if err := queryRecordType(&results, opts); err != nil {
+			return results, err
+		}
+		if !opts.noIDN {
+			results.decodeIDN()
+		}
+		return results, nil
+	}
+
+	// The record types are independent, so look them up concurrently; a slow or timed-out
+	// type then costs its own wait instead of adding to everyone else's
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		lookupErr error
+		cnameErr  error
+		found     bool
+	)
+	lookup := func(run func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run()
+		}()
+	}
+
+	// A and AAAA Record Lookup (IPv4 and IPv6)
+	lookup(func() {
+		ips, err := resolver.LookupIP(ctx, "ip", domain)
+		mu.Lock()
+		defer mu.Unlock()
+		lookupErr = err
+		found = found || len(ips) > 0
+		for _, ip := range ips {
 			if ip.To4() != nil {
 				results.A = append(results.A, ip.String())
+			} else if ip.To16() != nil {
+				results.AAAA = append(results.AAAA, ip.String())
 			}
 		}
-	}
+	})
+
+	// CNAME Lookup with chaining
+	lookup(func() {
+		chain, err := resolveCNAMEChain(ctx, opts.cnameResolver(), domain)
+		mu.Lock()
+		defer mu.Unlock()
+		cnameErr = err
+		found = found || len(chain) > 0
+		if len(chain) > 0 {
+			results.CNAME = chain
+		}
+	})
 
-	// AAAA Record Lookup (IPv6)
-	for _, ip := range aRecords {
-		if ip.To16() != nil && ip.To4() == nil {
-			results.AAAA = append(results.AAAA, ip.String())
+	// MX, NS and TXT records are shown only in full mode, but still tell whether the name has records
+	lookup(func() {
+		mxRecords, err := resolver.LookupMX(ctx, domain)
+		mu.Lock()
+		defer mu.Unlock()
+		found = found || len(mxRecords) > 0
+		if err == nil && !simpleMode {
+			for _, mx := range mxRecords {
+				results.MX = append(results.MX, MXRecord{Host: mx.Host, Priority: mx.Pref})
+			}
+		}
+	})
+	lookup(func() {
+		nsRecords, err := resolver.LookupNS(ctx, domain)
+		mu.Lock()
+		defer mu.Unlock()
+		found = found || len(nsRecords) > 0
+		if err == nil && !simpleMode {
+			for _, ns := range nsRecords {
+				results.NS = append(results.NS, ns.Host)
+			}
 		}
+	})
+	lookup(func() {
+		txtRecords, err := resolver.LookupTXT(ctx, domain)
+		mu.Lock()
+		defer mu.Unlock()
+		found = found || len(txtRecords) > 0
+		if err == nil && !simpleMode {
+			results.TXT = append(results.TXT, txtRecords...)
+		}
+	})
+	wg.Wait()
+
+	if cnameErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", cnameErr)
 	}
 
-	// CNAME Lookup with chaining
-	cnameChain := resolveCNAMEChain(domain)
-	if len(cnameChain) > 0 {
-		results.CNAME = cnameChain
+	// Tell an empty answer apart from a name that doesn't exist or a failed lookup
+	if found {
+		results.Status = "NOERROR"
+	} else {
+		results.Status = lookupStatus(domain, lookupErr, opts)
+	}
+
+	// Show internationalized names in Unicode rather than Punycode
+	if !opts.noIDN {
+		results.decodeIDN()
+	}
+	return results, nil
+}
+
+// lookupStatus classifies a name that produced no records. The stub resolver reports a
+// missing name and a name without records the same way, so that case is asked again with
+// the raw-query resolver, whose response carries the rcode.
+func lookupStatus(domain string, err error, opts digOptions) string {
+	var dnsErr *net.DNSError
+	if err != nil && errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsTimeout:
+			return "TIMEOUT"
+		case dnsErr.IsNotFound:
+			// Resolved below
+		case dnsErr.Err == "server misbehaving":
+			// Go's wording for a SERVFAIL or otherwise unusable answer
+			return "SERVFAIL"
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: lookup of %s failed: %v\n", domain, err)
+			return "ERROR"
+		}
 	}
 
-	// MX Record Lookup
-	mxRecords, err := net.LookupMX(domain)
-	if err == nil && !simpleMode { // Show MX records only in full mode
-		for _, mx := range mxRecords {
-			results.MX = append(results.MX, MXRecord{Host: mx.Host, Priority: mx.Pref})
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	query := dnsQuery{
+		server:  opts.server,
+		name:    domain,
+		qtype:   dns.TypeA,
+		bufsize: opts.bufsize,
+		timeout: timeout,
+		source:  opts.source,
+		ecs:     opts.ecs,
+	}
+	resp, _, qerr := query.exchange()
+	if qerr != nil {
+		var netErr net.Error
+		if errors.As(qerr, &netErr) && netErr.Timeout() {
+			return "TIMEOUT"
 		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", qerr)
+		return "ERROR"
+	}
+	if resp.Rcode == dns.RcodeSuccess {
+		// The name exists; it just has none of the records looked up
+		return "NODATA"
+	}
+	return responseStatus(resp)
+}
+
+// queryRecordType looks up a single record type with the raw-query resolver and adds the answers to results
+func queryRecordType(results *DNSResults, opts digOptions) error {
+	qtype, err := parseQueryType(opts.queryType)
+	if err != nil {
+		return err
+	}
+
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	query := dnsQuery{
+		server:  opts.server,
+		name:    results.Domain,
+		qtype:   qtype,
+		qclass:  opts.class,
+		bufsize: opts.bufsize,
+		timeout: timeout,
+		source:  opts.source,
+		ecs:     opts.ecs,
+		cache:   opts.cache,
+	}
+	resp, info, err := query.exchange()
+	if err != nil {
+		return err
 	}
 
-	// NS Record Lookup (Name Servers)
-	nsRecords, err := net.LookupNS(domain)
-	if err == nil && !simpleMode { // Show NS records only in full mode
-		for _, ns := range nsRecords {
-			results.NS = append(results.NS, ns.Host)
+	// Keep stdout valid YAML; the size report goes to stderr
+	printMsgSize(os.Stderr, resp, info)
+	results.Status = responseStatus(resp)
+	addAnswers(results, resp.Answer)
+	return nil
+}
+
+// compareTypes are the record types compared by --compare when --type is not given
+var compareTypes = []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeMX, dns.TypeNS, dns.TypeTXT}
+
+// compareResolvers queries both resolvers for each record type and prints which record
+// sets match and which differ. It reports whether all answers were identical.
+func compareResolvers(domain string, servers []string, opts digOptions) (bool, error) {
+	if len(servers) != 2 {
+		return false, fmt.Errorf("--compare takes exactly two resolvers, got %d", len(servers))
+	}
+
+	types := compareTypes
+	if opts.queryType != "" {
+		qtype, err := parseQueryType(opts.queryType)
+		if err != nil {
+			return false, err
 		}
+		types = []uint16{qtype}
 	}
 
-	// TXT Record Lookup
-	txtRecords, err := net.LookupTXT(domain)
-	if err == nil && !simpleMode { // Show TXT records only in full mode
-		results.TXT = append(results.TXT, txtRecords...)
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
 	}
 
-	// Handle printing results
-	if simpleMode {
-		// Only show CNAME and A/AAAA records in YAML
-		printSimpleResults(results)
+	fmt.Printf("Comparing %s: %s vs %s\n", domain, servers[0], servers[1])
+	same := true
+	for _, qtype := range types {
+		var answers [2][]string
+		for i, server := range servers {
+			query := dnsQuery{
+				server:  nameserverAddress(server),
+				name:    domain,
+				qtype:   qtype,
+				qclass:  opts.class,
+				bufsize: opts.bufsize,
+				timeout: timeout,
+				source:  opts.source,
+				ecs:     opts.ecs,
+			}
+			resp, _, err := query.exchange()
+			if err != nil {
+				return false, err
+			}
+			answers[i] = normalizeAnswers(resp.Answer, qtype)
+		}
+
+		onlyFirst, onlySecond := diffRecordSets(answers[0], answers[1])
+		typeName := dns.TypeToString[qtype]
+		if len(onlyFirst) == 0 && len(onlySecond) == 0 {
+			records := strings.Join(answers[0], ", ")
+			if records == "" {
+				records = "(no records)"
+			}
+			fmt.Printf("  %-6s match   %s\n", typeName, records)
+			continue
+		}
+
+		same = false
+		fmt.Printf("  %-6s DIFFER\n", typeName)
+		for _, record := range onlyFirst {
+			fmt.Printf("         only %s: %s\n", servers[0], record)
+		}
+		for _, record := range onlySecond {
+			fmt.Printf("         only %s: %s\n", servers[1], record)
+		}
+	}
+
+	if same {
+		fmt.Println("Resolvers agree.")
 	} else {
-		// Print all results in YAML format
-		yamlOutput, err := yaml.Marshal(&results)
+		fmt.Println("Resolvers disagree.")
+	}
+	return same, nil
+}
+
+// normalizeAnswers returns the sorted record data of the answers of the given type,
+// ignoring TTLs and record order so that equivalent answers compare equal
+func normalizeAnswers(answers []dns.RR, qtype uint16) []string {
+	var records []string
+	for _, rr := range answers {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		records = append(records, strings.ToLower(rdataString(rr)))
+	}
+	sort.Strings(records)
+	return records
+}
+
+// diffRecordSets returns the records only present in a and those only present in b
+func diffRecordSets(a, b []string) (onlyA, onlyB []string) {
+	inA := make(map[string]bool, len(a))
+	for _, record := range a {
+		inA[record] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, record := range b {
+		inB[record] = true
+		if !inA[record] {
+			onlyB = append(onlyB, record)
+		}
+	}
+	for _, record := range a {
+		if !inB[record] {
+			onlyA = append(onlyA, record)
+		}
+	}
+	return onlyA, onlyB
+}
+
+// zoneTypes are the record types printed by --output zone when --type is not given
+var zoneTypes = []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA, dns.TypeMX, dns.TypeNS, dns.TypeTXT}
+
+// printZone queries the record types with the raw-query resolver and prints each answer
+// in zone-file presentation format, e.g. "example.com.	300	IN	A	93.184.216.34"
+func printZone(domain string, opts digOptions) error {
+	types := zoneTypes
+	if opts.simple {
+		types = []uint16{dns.TypeCNAME, dns.TypeA, dns.TypeAAAA}
+	}
+	if opts.queryType != "" {
+		qtype, err := parseQueryType(opts.queryType)
 		if err != nil {
-			fmt.Printf("Error marshaling to YAML: %v\n", err)
-			os.Exit(1)
+			return err
 		}
-		fmt.Println(string(yamlOutput))
+		types = []uint16{qtype}
 	}
+
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	// Address queries also return the CNAME chain, so skip records already printed
+	printed := make(map[string]bool)
+	for _, qtype := range types {
+		query := dnsQuery{
+			server:  opts.server,
+			name:    domain,
+			qtype:   qtype,
+			qclass:  opts.class,
+			bufsize: opts.bufsize,
+			timeout: timeout,
+			source:  opts.source,
+			ecs:     opts.ecs,
+			cache:   opts.cache,
+		}
+		resp, info, err := query.exchange()
+		if err != nil {
+			return err
+		}
+		if status := responseStatus(resp); status != "NOERROR" {
+			fmt.Printf(";; %s %s: %s\n", domain, dns.TypeToString[qtype], status)
+		}
+		for _, rr := range resp.Answer {
+			line := rr.String()
+			if printed[line] {
+				continue
+			}
+			printed[line] = true
+			fmt.Println(line)
+		}
+		// ";;" lines are comments in zone files, so the report can stay on stdout
+		printMsgSize(os.Stdout, resp, info)
+	}
+	return nil
+}
+
+// printTransfer performs a zone transfer of domain and prints every record of the zone
+func printTransfer(domain string, opts digOptions) error {
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	query := dnsQuery{
+		server:  opts.server,
+		name:    domain,
+		timeout: timeout,
+		source:  opts.source,
+	}
+	records, stats, err := query.transfer()
+	if err != nil {
+		return err
+	}
+
+	for _, rr := range records {
+		fmt.Println(rr.String())
+	}
+	fmt.Printf(";; XFR size: %d records (messages %d, bytes %d)\n", len(records), stats.messages, stats.bytes)
+	return nil
+}
+
+// printResults prints the DNS results in YAML format
+func printResults(results DNSResults) {
+	yamlOutput, err := yaml.Marshal(&results)
+	if err != nil {
+		fatalf("Error marshaling to YAML: %v", err)
+	}
+	fmt.Println(string(yamlOutput))
+}
+
+// maxCNAMEChain bounds the number of hops followed by resolveCNAMEChain
+const maxCNAMEChain = 16
+
+// cnameResolver looks up the CNAME record of a single name, returning its target or ""
+// when the name is not an alias. Chains must be followed one hop at a time: the stub
+// resolver's LookupCNAME already returns the end of the chain.
+type cnameResolver interface {
+	lookupCNAME(name string) (string, error)
+}
+
+// dnsCNAMEResolver sends CNAME queries through the raw-query resolver; query holds the
+// server and settings shared by every hop
+type dnsCNAMEResolver struct {
+	query dnsQuery
+}
+
+func (r dnsCNAMEResolver) lookupCNAME(name string) (string, error) {
+	query := r.query
+	query.name = name
+	query.qtype = dns.TypeCNAME
+	resp, _, err := query.exchange()
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && normalizeName(cname.Hdr.Name) == normalizeName(name) {
+			return cname.Target, nil
+		}
+	}
+	return "", nil
+}
+
+// cnameResolver returns the resolver following CNAME chains for dig
+func (o digOptions) cnameResolver() cnameResolver {
+	timeout := o.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return dnsCNAMEResolver{query: dnsQuery{
+		server:  o.server,
+		qclass:  o.class,
+		bufsize: o.bufsize,
+		timeout: timeout,
+		cache:   o.cache,
+		source:  o.source,
+		ecs:     o.ecs,
+	}}
 }
 
-// resolveCNAMEChain resolves a chain of CNAMEs starting from the initial domain
-func resolveCNAMEChain(domain string) []string {
+// resolveCNAMEChain resolves a chain of CNAMEs starting from the initial domain. It stops
+// with an error, returning the chain so far, when a query fails, ctx is done, a name
+// repeats or the chain grows past maxCNAMEChain hops.
+func resolveCNAMEChain(ctx context.Context, resolver cnameResolver, domain string) ([]string, error) {
 	var cnameChain []string
+	visited := map[string]bool{normalizeName(domain): true}
 
 	for {
-		cname, err := net.LookupCNAME(domain)
+		if err := ctx.Err(); err != nil {
+			return cnameChain, err
+		}
+		cname, err := resolver.lookupCNAME(domain)
 		if err != nil {
-			break
+			return cnameChain, err
 		}
 
-		// If the CNAME is the same as the domain, we've reached the final point
-		if cname == domain {
+		// A name without a CNAME record is the end of the chain
+		if cname == "" {
 			break
 		}
 
+		// A name seen before means the zone points back into the chain
+		if visited[normalizeName(cname)] {
+			return cnameChain, fmt.Errorf("CNAME loop detected: %s -> %s", domain, cname)
+		}
+		if len(cnameChain) >= maxCNAMEChain {
+			return cnameChain, fmt.Errorf("CNAME chain longer than %d hops", maxCNAMEChain)
+		}
+		visited[normalizeName(cname)] = true
+
 		// Add the CNAME to the chain
 		cnameChain = append(cnameChain, cname)
 
@@ -137,24 +904,83 @@ func resolveCNAMEChain(domain string) []string {
 		domain = cname
 	}
 
-	return cnameChain
+	return cnameChain, nil
+}
+
+// normalizeName lower-cases a domain name and strips the trailing dot so names compare equal
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// printChain resolves the CNAME chain of domain and prints it followed by the addresses
+// the last name resolves to, e.g. "www.example.com → cdn.example.net → 1.2.3.4, 5.6.7.8"
+func printChain(domain string, opts digOptions) error {
+	hops, err := chainHops(domain, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.Join(hops, " → "))
+	return nil
+}
+
+// chainHops follows the CNAME chain of domain one hop at a time and returns every name
+// in it, ending with the final addresses, or with the error that cut the chain short
+func chainHops(domain string, opts digOptions) ([]string, error) {
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	hops := []string{strings.TrimSuffix(domain, ".")}
+	cnameChain, chainErr := resolveCNAMEChain(ctx, opts.cnameResolver(), domain)
+	for _, cname := range cnameChain {
+		hops = append(hops, strings.TrimSuffix(cname, "."))
+	}
+
+	// A loop or failed hop has no final answer; show how far the chain got
+	if chainErr != nil {
+		return append(hops, "("+chainErr.Error()+")"), nil
+	}
+
+	addrs, err := opts.resolver().LookupIPAddr(ctx, hops[len(hops)-1])
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP.String())
+	}
+	hops = append(hops, strings.Join(ips, ", "))
+	if !opts.noIDN {
+		for i := range hops[:len(hops)-1] {
+			hops[i] = idnToUnicode(hops[i])
+		}
+	}
+	return hops, nil
 }
 
 // printSimpleResults prints only CNAME and A/AAAA records in YAML format
 func printSimpleResults(results DNSResults) {
-	simpleResults := DNSResults{
-		Domain: results.Domain,
-		CNAME:  results.CNAME,
-		A:      results.A,
-		AAAA:   results.AAAA,
-	}
+	simpleResults := results.simplified()
 
 	// Convert the simple results to YAML and print
 	yamlOutput, err := yaml.Marshal(&simpleResults)
 	if err != nil {
-		fmt.Printf("Error marshaling to YAML: %v\n", err)
-		os.Exit(1)
+		fatalf("Error marshaling to YAML: %v", err)
 	}
 
 	fmt.Println(string(yamlOutput))
 }
+
+// simplified keeps only the status, the CNAME chain and the addresses, as shown by -s
+func (r DNSResults) simplified() DNSResults {
+	return DNSResults{
+		Domain: r.Domain,
+		Status: r.Status,
+		CNAME:  r.CNAME,
+		A:      r.A,
+		AAAA:   r.AAAA,
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// benchmarkResult is the outcome of one benchmark query
+type benchmarkResult struct {
+	rtt    time.Duration
+	status string // the response code, empty if no response arrived
+}
+
+// benchmarkResolver sends the same query queries times through the raw-query resolver,
+// with at most concurrency queries in flight, and prints a dnsperf-style summary of the
+// throughput, response codes and latency
+func benchmarkResolver(domain string, opts digOptions, queries, concurrency int) error {
+	if queries < 1 || concurrency < 1 {
+		return fmt.Errorf("--queries and --concurrency must be at least 1")
+	}
+	qtype := dns.TypeA
+	if opts.queryType != "" {
+		var err error
+		qtype, err = parseQueryType(opts.queryType)
+		if err != nil {
+			return err
+		}
+	}
+
+	server := opts.server
+	if server == "" {
+		var err error
+		server, err = defaultNameserver()
+		if err != nil {
+			return err
+		}
+	}
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	query := dnsQuery{
+		server:  server,
+		name:    domain,
+		qtype:   qtype,
+		qclass:  opts.class,
+		bufsize: opts.bufsize,
+		timeout: timeout,
+		source:  opts.source,
+		ecs:     opts.ecs,
+	}
+
+	fmt.Printf("Benchmarking %s with %d %s queries for %s (%d concurrent)\n\n",
+		server, queries, dns.TypeToString[qtype], domain, concurrency)
+
+	results := make([]benchmarkResult, queries)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < min(concurrency, queries); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				resp, info, err := query.exchange()
+				if err != nil {
+					continue // lost: the result keeps its empty status
+				}
+				results[i] = benchmarkResult{rtt: info.rtt, status: dns.RcodeToString[resp.Rcode]}
+			}
+		}()
+	}
+	for i := 0; i < queries; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var rtts []time.Duration
+	statuses := make(map[string]int)
+	for _, result := range results {
+		if result.status == "" {
+			continue
+		}
+		rtts = append(rtts, result.rtt)
+		statuses[result.status]++
+	}
+	completed := len(rtts)
+	lost := queries - completed
+
+	fmt.Printf("  Queries sent:         %d\n", queries)
+	fmt.Printf("  Queries completed:    %d (%.2f%%)\n", completed, percentOf(completed, queries))
+	fmt.Printf("  Queries lost:         %d (%.2f%%)\n", lost, percentOf(lost, queries))
+	if completed > 0 {
+		codes := make([]string, 0, len(statuses))
+		for status := range statuses {
+			codes = append(codes, status)
+		}
+		sort.Strings(codes)
+		var parts []string
+		for _, status := range codes {
+			parts = append(parts, fmt.Sprintf("%s %d (%.2f%%)", status, statuses[status], percentOf(statuses[status], completed)))
+		}
+		fmt.Printf("  Response codes:       %s\n", strings.Join(parts, ", "))
+	}
+	fmt.Printf("  Run time (s):         %.3f\n", elapsed.Seconds())
+	fmt.Printf("  Queries per second:   %.1f\n", float64(completed)/elapsed.Seconds())
+	if completed == 0 {
+		return fmt.Errorf("no responses from %s", server)
+	}
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	var total time.Duration
+	for _, rtt := range rtts {
+		total += rtt
+	}
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	fmt.Printf("\n  Latency (ms):         min %.3f, avg %.3f, max %.3f\n",
+		ms(rtts[0]), ms(total/time.Duration(completed)), ms(rtts[completed-1]))
+	fmt.Printf("  Percentiles (ms):     p50 %.3f, p90 %.3f, p99 %.3f\n",
+		ms(percentile(rtts, 50)), ms(percentile(rtts, 90)), ms(percentile(rtts, 99)))
+	return nil
+}
+
+// percentile returns the p-th percentile of sorted durations by the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+// percentOf returns n as a percentage of total
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
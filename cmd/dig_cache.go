@@ -0,0 +1,186 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+// digCacheCmd groups the commands that manage the answer cache used by dig --cache
+var digCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local cache of DNS answers used by dig --cache",
+}
+
+// digCacheClearCmd removes every cached answer
+var digCacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all cached DNS answers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := dnsCachePath()
+		if err != nil {
+			fatalf("Error clearing cache: %v", err)
+		}
+		cache, err := loadDNSCache(path)
+		if err != nil {
+			fatalf("Error clearing cache: %v", err)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fatalf("Error clearing cache: %v", err)
+		}
+		fmt.Printf("Removed %d cached answers from %s\n", len(cache.entries), path)
+	},
+}
+
+func init() {
+	digCmd.AddCommand(digCacheCmd)
+	digCacheCmd.AddCommand(digCacheClearCmd)
+}
+
+// dnsCacheEntry is a cached response in wire format along with its lifetime
+type dnsCacheEntry struct {
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+	Msg     []byte    `json:"msg"`
+}
+
+// dnsCache is a small file-backed store of raw-query responses, keyed by question and
+// server, that serves each response until the lowest TTL in it runs out
+type dnsCache struct {
+	path    string
+	entries map[string]dnsCacheEntry
+}
+
+// dnsCachePath returns the location of the cache file in the user's cache directory
+func dnsCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the cache directory: %v", err)
+	}
+	return filepath.Join(dir, "netro", "dig-cache.json"), nil
+}
+
+// loadDNSCache reads the cache file at path; a missing file is an empty cache
+func loadDNSCache(path string) (*dnsCache, error) {
+	cache := &dnsCache{path: path, entries: make(map[string]dnsCacheEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache: %v", err)
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		return nil, fmt.Errorf("malformed cache file %s: %v", path, err)
+	}
+	return cache, nil
+}
+
+// dnsCacheKey identifies a question asked of a server
+func dnsCacheKey(msg *dns.Msg, server string) string {
+	q := msg.Question[0]
+	key := fmt.Sprintf("%s %s %s @%s", strings.ToLower(q.Name), dns.ClassToString[q.Qclass], dns.TypeToString[q.Qtype], server)
+	// Answers tailored to a client subnet are only good for that subnet
+	if opt := msg.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+				key += fmt.Sprintf(" ecs=%s/%d", subnet.Address, subnet.SourceNetmask)
+			}
+		}
+	}
+	return key
+}
+
+// get returns the cached response for key if it has not expired, with its TTLs reduced
+// by the time it spent in the cache
+func (c *dnsCache) get(key string, now time.Time) (*dns.Msg, int, bool) {
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.Expires) {
+		return nil, 0, false
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(entry.Msg); err != nil {
+		return nil, 0, false
+	}
+
+	age := uint32(now.Sub(entry.Stored).Seconds())
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			rr.Header().Ttl -= min(age, rr.Header().Ttl)
+		}
+	}
+	return resp, len(entry.Msg), true
+}
+
+// put stores resp under key for as long as its TTLs allow and writes the cache file.
+// Responses that carry no TTL, such as SERVFAIL, are not cached.
+func (c *dnsCache) put(key string, resp *dns.Msg, now time.Time) error {
+	ttl, ok := cacheTTL(resp)
+	if !ok || ttl == 0 {
+		return nil
+	}
+	wire, err := resp.Pack()
+	if err != nil {
+		return err
+	}
+	c.entries[key] = dnsCacheEntry{
+		Stored:  now,
+		Expires: now.Add(time.Duration(ttl) * time.Second),
+		Msg:     wire,
+	}
+	return c.save(now)
+}
+
+// save writes the unexpired entries to the cache file
+func (c *dnsCache) save(now time.Time) error {
+	for key, entry := range c.entries {
+		if !now.Before(entry.Expires) {
+			delete(c.entries, key)
+		}
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	if err := os.WriteFile(c.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cache: %v", err)
+	}
+	return nil
+}
+
+// cacheTTL returns how long a response may be cached: the lowest TTL of its answers,
+// or for NXDOMAIN and NODATA the negative-caching TTL from the SOA record (RFC 2308)
+func cacheTTL(resp *dns.Msg) (uint32, bool) {
+	switch {
+	case resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0:
+		ttl := resp.Answer[0].Header().Ttl
+		for _, rr := range resp.Answer[1:] {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+		return ttl, true
+	case resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError:
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return min(soa.Hdr.Ttl, soa.Minttl), true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// expectTypes are the record types with an --expect-<type> flag
+var expectTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT"}
+
+// dnsExpectation is a record that --expect-<type> requires the answers to contain
+type dnsExpectation struct {
+	recordType string
+	value      string
+}
+
+// expectedRecords returns the answers of one record type from the results; MX records
+// are given as "priority host"
+func expectedRecords(results DNSResults, recordType string) []string {
+	switch recordType {
+	case "A":
+		return results.A
+	case "AAAA":
+		return results.AAAA
+	case "CNAME":
+		return results.CNAME
+	case "MX":
+		mx := make([]string, 0, len(results.MX))
+		for _, record := range results.MX {
+			mx = append(mx, fmt.Sprintf("%d %s", record.Priority, record.Host))
+		}
+		return mx
+	case "NS":
+		return results.NS
+	case "TXT":
+		return results.TXT
+	}
+	return nil
+}
+
+// recordMatches reports whether an answer is the expected value. Addresses are compared
+// as IPs and names without case or trailing dot; an MX value may omit the priority.
+func recordMatches(recordType, record, value string) bool {
+	switch recordType {
+	case "A", "AAAA":
+		ip := net.ParseIP(value)
+		return ip != nil && ip.Equal(net.ParseIP(record))
+	case "MX":
+		if !strings.Contains(strings.TrimSpace(value), " ") {
+			_, host, _ := strings.Cut(record, " ")
+			return sameName(host, value)
+		}
+		priority, host, _ := strings.Cut(record, " ")
+		fields := strings.Fields(value)
+		return len(fields) == 2 && fields[0] == priority && sameName(host, fields[1])
+	case "CNAME", "NS":
+		return sameName(record, value)
+	}
+	return record == value
+}
+
+// sameName compares two domain names, ignoring case and a trailing dot
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(a), "."), strings.TrimSuffix(strings.TrimSpace(b), "."))
+}
+
+// checkExpectations prints PASS or FAIL, with the answers found, for every expected
+// record, and reports whether all of them were found
+func checkExpectations(results DNSResults, expectations []dnsExpectation) bool {
+	failed := 0
+	for _, e := range expectations {
+		records := expectedRecords(results, e.recordType)
+		met := false
+		for _, record := range records {
+			if recordMatches(e.recordType, record, e.value) {
+				met = true
+				break
+			}
+		}
+
+		found := strings.Join(records, ", ")
+		if len(records) == 0 {
+			found = "no " + e.recordType + " records, status " + results.Status
+		}
+		verdict := "PASS"
+		if !met {
+			verdict = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s  %s %s %s (found: %s)\n", verdict, results.Domain, e.recordType, e.value, found)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d expectations failed.\n", failed, len(expectations))
+		return false
+	}
+	fmt.Printf("All %d expectations met.\n", len(expectations))
+	return true
+}
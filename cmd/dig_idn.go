@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// idnProfile converts names like idna.Lookup, but without the STD3 host name rules, which
+// reject the "_" labels of service names (_dmarc, _sip._tcp) and wildcard labels
+var idnProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false))
+
+// idnToASCII converts an internationalized domain name such as "bücher.example" to the
+// Punycode form used on the wire ("xn--bcher-kva.example"); ASCII names pass unchanged
+func idnToASCII(name string) (string, error) {
+	trimmed := strings.TrimSuffix(name, ".")
+	ascii, err := idnProfile.ToASCII(trimmed)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized domain name %q: %v", name, err)
+	}
+	return ascii + name[len(trimmed):], nil
+}
+
+// idnToUnicode converts the Punycode labels of a name back to Unicode for display. Names
+// that don't decode cleanly are shown as they are.
+func idnToUnicode(name string) string {
+	trimmed := strings.TrimSuffix(name, ".")
+	unicode, err := idnProfile.ToUnicode(trimmed)
+	if err != nil {
+		return name
+	}
+	return unicode + name[len(trimmed):]
+}
+
+// decodeIDN converts the domain names in the results to Unicode for display
+func (r *DNSResults) decodeIDN() {
+	r.Domain = idnToUnicode(r.Domain)
+	for i := range r.CNAME {
+		r.CNAME[i] = idnToUnicode(r.CNAME[i])
+	}
+	for i := range r.MX {
+		r.MX[i].Host = idnToUnicode(r.MX[i].Host)
+	}
+	for i := range r.NS {
+		r.NS[i] = idnToUnicode(r.NS[i])
+	}
+	for _, records := range [][]SVCBRecord{r.HTTPS, r.SVCB} {
+		for i := range records {
+			records[i].Target = idnToUnicode(records[i].Target)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// publicResolver is a resolver asked by --propagation
+type publicResolver struct {
+	name string // provider, or the address itself for --resolvers entries
+	addr string // host:port
+}
+
+// publicResolvers are the well-known public resolvers --propagation asks by default
+var publicResolvers = []publicResolver{
+	{"Google", "8.8.8.8:53"},
+	{"Cloudflare", "1.1.1.1:53"},
+	{"Quad9", "9.9.9.9:53"},
+	{"OpenDNS", "208.67.222.222:53"},
+}
+
+// propagationAnswer is one resolver's answer to the propagation query
+type propagationAnswer struct {
+	status  string
+	records []string // sorted record data, without TTLs
+	ttl     uint32   // lowest TTL of the records
+	err     error    // why the query failed, if it did
+}
+
+// key identifies the answer, so that resolvers agreeing on it can be grouped
+func (a propagationAnswer) key() string {
+	return a.status + " " + strings.Join(a.records, ", ")
+}
+
+// checkPropagation asks every resolver for the same record at once and prints a table of
+// their answers, marking those that differ from the most common one. It reports whether
+// all resolvers returned the same answer.
+func checkPropagation(domain string, resolvers []publicResolver, opts digOptions) (bool, error) {
+	qtype := dns.TypeA
+	if opts.queryType != "" {
+		var err error
+		qtype, err = parseQueryType(opts.queryType)
+		if err != nil {
+			return false, err
+		}
+	}
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	answers := make([]propagationAnswer, len(resolvers))
+	var wg sync.WaitGroup
+	for i, resolver := range resolvers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query := dnsQuery{
+				server:  resolver.addr,
+				name:    domain,
+				qtype:   qtype,
+				qclass:  opts.class,
+				bufsize: opts.bufsize,
+				timeout: timeout,
+				source:  opts.source,
+				ecs:     opts.ecs,
+			}
+			answers[i] = askResolver(query)
+		}()
+	}
+	wg.Wait()
+
+	keys := make([]string, len(answers))
+	for i, answer := range answers {
+		keys[i] = answer.key()
+	}
+	common, distinct := mostCommonAnswer(keys)
+
+	fmt.Printf("Propagation of %s %s across %d resolvers\n\n", domain, dns.TypeToString[qtype], len(resolvers))
+	fmt.Printf("%-28s %-9s %6s  %s\n", "RESOLVER", "STATUS", "TTL", "ANSWER")
+	for i, resolver := range resolvers {
+		answer := answers[i]
+		name := resolver.addr
+		if resolver.name != "" {
+			name = fmt.Sprintf("%s (%s)", resolver.name, strings.TrimSuffix(resolver.addr, ":53"))
+		}
+		ttl, records := "-", "-"
+		if len(answer.records) > 0 {
+			ttl = fmt.Sprint(answer.ttl)
+			records = strings.Join(answer.records, ", ")
+		}
+		marker := ""
+		if distinct > 1 && keys[i] != common {
+			marker = "  <- differs"
+		}
+		fmt.Printf("%-28s %-9s %6s  %s%s\n", name, answer.status, ttl, records, marker)
+	}
+	for _, answer := range answers {
+		if answer.err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", answer.err)
+		}
+	}
+
+	fmt.Println()
+	if distinct == 1 {
+		fmt.Printf("All %d resolvers return the same answer.\n", len(resolvers))
+		return true, nil
+	}
+	fmt.Printf("Not propagated: the resolvers return %d different answers.\n", distinct)
+	return false, nil
+}
+
+// askResolver sends the query and condenses the response into the resolver's answer;
+// failures become a TIMEOUT or ERROR status
+func askResolver(query dnsQuery) propagationAnswer {
+	resp, _, err := query.exchange()
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return propagationAnswer{status: "TIMEOUT", err: err}
+		}
+		return propagationAnswer{status: "ERROR", err: err}
+	}
+	answer := propagationAnswer{status: responseStatus(resp), records: normalizeAnswers(resp.Answer, query.qtype)}
+	first := true
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype != query.qtype {
+			continue
+		}
+		if first || rr.Header().Ttl < answer.ttl {
+			answer.ttl = rr.Header().Ttl
+			first = false
+		}
+	}
+	return answer
+}
+
+// mostCommonAnswer returns the answer key given most often, the first one seen on a tie,
+// and the number of distinct keys
+func mostCommonAnswer(keys []string) (string, int) {
+	counts := make(map[string]int)
+	for _, key := range keys {
+		counts[key]++
+	}
+	var common string
+	for _, key := range keys {
+		if counts[key] > counts[common] {
+			common = key
+		}
+	}
+	return common, len(counts)
+}
+
+// parseResolverList turns the --resolvers addresses into resolvers named after them
+func parseResolverList(servers []string) []publicResolver {
+	resolvers := make([]publicResolver, 0, len(servers))
+	for _, server := range servers {
+		resolvers = append(resolvers, publicResolver{addr: nameserverAddress(server)})
+	}
+	return resolvers
+}
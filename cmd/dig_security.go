@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// securityTests are the DNS-OARC test names used by --security-check. Resolving one
+// makes the resolver send a series of queries to DNS-OARC's servers, which observe the
+// source ports or query IDs the resolver used and answer with a TXT verdict.
+var securityTests = []struct {
+	name  string
+	label string
+}{
+	{"porttest.dns-oarc.net", "Source ports"},
+	{"txidtest.dns-oarc.net", "Query IDs"},
+}
+
+// securityVerdict matches DNS-OARC's answer, e.g. "192.0.2.1 is GREAT: 26 queries in
+// 1.7 seconds from 26 ports with std dev 17685.58"
+var securityVerdict = regexp.MustCompile(`is (\w+): (\d+) queries in ([\d.]+) seconds from (\d+) (?:ports|txids) with std dev ([\d.]+)`)
+
+// securityResult is the parsed DNS-OARC verdict for one test
+type securityResult struct {
+	rating  string
+	queries int
+	unique  int
+	stdDev  float64
+}
+
+// entropyBits estimates the randomness behind a standard deviation, assuming the values
+// are spread uniformly: a uniform range of n values has a standard deviation of n/√12
+func (r securityResult) entropyBits() float64 {
+	if r.stdDev <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Log2(r.stdDev*math.Sqrt(12)))
+}
+
+// passed reports whether DNS-OARC rated the randomness good enough against spoofing
+func (r securityResult) passed() bool {
+	return r.rating == "GREAT" || r.rating == "GOOD"
+}
+
+// parseSecurityVerdict extracts the verdict from DNS-OARC's TXT answer
+func parseSecurityVerdict(txt string) (securityResult, error) {
+	match := securityVerdict.FindStringSubmatch(txt)
+	if match == nil {
+		return securityResult{}, fmt.Errorf("unexpected answer %q", txt)
+	}
+	queries, _ := strconv.Atoi(match[2])
+	unique, _ := strconv.Atoi(match[4])
+	stdDev, _ := strconv.ParseFloat(match[5], 64)
+	return securityResult{rating: match[1], queries: queries, unique: unique, stdDev: stdDev}, nil
+}
+
+// securityCheck asks the resolver to resolve the DNS-OARC test names and reports how
+// random its source ports and query IDs are, with a PASS or WARN verdict for each
+func securityCheck(opts digOptions) error {
+	// Each test makes the resolver send a few dozen queries of its own
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+
+	resolver := opts.server
+	if resolver == "" {
+		resolver = "system resolver"
+	}
+	fmt.Printf("Checking query randomization of %s\n", resolver)
+
+	for _, test := range securityTests {
+		query := dnsQuery{
+			server:  opts.server,
+			name:    test.name,
+			qtype:   dns.TypeTXT,
+			bufsize: opts.bufsize,
+			timeout: timeout,
+			source:  opts.source,
+		}
+		resp, _, err := query.exchange()
+		if err != nil {
+			return err
+		}
+
+		var txt string
+		for _, rr := range resp.Answer {
+			if record, ok := rr.(*dns.TXT); ok {
+				txt = strings.Join(record.Txt, "")
+			}
+		}
+		if txt == "" {
+			fmt.Printf("  %-13s UNKNOWN  no answer for %s (%s)\n", test.label, test.name, responseStatus(resp))
+			continue
+		}
+
+		result, err := parseSecurityVerdict(txt)
+		if err != nil {
+			return fmt.Errorf("%s: %v", test.name, err)
+		}
+		verdict := "PASS"
+		if !result.passed() {
+			verdict = "WARN"
+		}
+		fmt.Printf("  %-13s %-8s %s: %d distinct in %d queries, std dev %.0f (≈%.1f bits of entropy)\n",
+			test.label, verdict, result.rating, result.unique, result.queries, result.stdDev, result.entropyBits())
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v2"
+)
+
+// saveDNSSnapshot writes the results to path in the YAML dig prints, for a later --snapshot-diff
+func saveDNSSnapshot(path string, results DNSResults) error {
+	data, err := yaml.Marshal(&results)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save snapshot: %v", err)
+	}
+	return nil
+}
+
+// loadDNSSnapshot reads results saved by --snapshot-save
+func loadDNSSnapshot(path string) (DNSResults, error) {
+	var results DNSResults
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return results, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &results); err != nil {
+		return results, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	return results, nil
+}
+
+// snapshotRecordSet is one field of DNSResults, with its records in a comparable form
+type snapshotRecordSet struct {
+	name    string
+	records []string
+}
+
+// snapshotRecordSets lists the fields of the results in output order, each with its
+// records sorted so that answers returned in another order still compare equal
+func snapshotRecordSets(r DNSResults) []snapshotRecordSet {
+	mx := make([]string, 0, len(r.MX))
+	for _, record := range r.MX {
+		mx = append(mx, fmt.Sprintf("%d %s", record.Priority, record.Host))
+	}
+	status := []string{}
+	if r.Status != "" {
+		status = append(status, r.Status)
+	}
+	sets := []snapshotRecordSet{
+		{"status", status},
+		{"A", r.A},
+		{"AAAA", r.AAAA},
+		{"CNAME", r.CNAME},
+		{"MX", mx},
+		{"NS", r.NS},
+		{"TXT", r.TXT},
+		{"HTTPS", svcbStrings(r.HTTPS)},
+		{"SVCB", svcbStrings(r.SVCB)},
+		{"other", otherRecordStrings(r.Other)},
+	}
+	for i := range sets {
+		records := append([]string{}, sets[i].records...)
+		sort.Strings(records)
+		sets[i].records = records
+	}
+	return sets
+}
+
+// otherRecordStrings drops the TTLs from records kept in presentation format, which
+// a caching resolver counts down between runs, leaving the owner, type and rdata
+func otherRecordStrings(records []string) []string {
+	out := make([]string, 0, len(records))
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil || rr == nil {
+			out = append(out, record)
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s %s %s", rr.Header().Name, dns.TypeToString[rr.Header().Rrtype], rdataString(rr)))
+	}
+	return out
+}
+
+// svcbStrings formats SVCB or HTTPS records as "priority target key=value ...", with
+// the parameters sorted by key
+func svcbStrings(records []SVCBRecord) []string {
+	out := make([]string, 0, len(records))
+	for _, record := range records {
+		parts := []string{fmt.Sprint(record.Priority), record.Target}
+		keys := make([]string, 0, len(record.Params))
+		for key := range record.Params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			parts = append(parts, key+"="+record.Params[key])
+		}
+		out = append(out, strings.Join(parts, " "))
+	}
+	return out
+}
+
+// diffDNSSnapshot compares fresh results with a snapshot field by field and prints the
+// records that were removed (-) or added (+). It reports whether nothing changed.
+func diffDNSSnapshot(path string, old, current DNSResults) bool {
+	fmt.Printf("Comparing %s with snapshot %s\n", current.Domain, path)
+	oldSets := snapshotRecordSets(old)
+	changed := 0
+	for i, set := range snapshotRecordSets(current) {
+		before := oldSets[i].records
+		if len(before) == 0 && len(set.records) == 0 {
+			continue
+		}
+		removed, added := diffRecordSets(before, set.records)
+		if len(removed) == 0 && len(added) == 0 {
+			fmt.Printf("  %-6s same      %s\n", set.name, strings.Join(set.records, ", "))
+			continue
+		}
+
+		changed++
+		fmt.Printf("  %-6s CHANGED\n", set.name)
+		for _, record := range removed {
+			fmt.Printf("         - %s\n", record)
+		}
+		for _, record := range added {
+			fmt.Printf("         + %s\n", record)
+		}
+	}
+
+	if changed == 0 {
+		fmt.Println("No changes.")
+		return true
+	}
+	fmt.Printf("%d record set(s) changed.\n", changed)
+	return false
+}
+
+// snapshotDNS looks up domain and, with diffPath, compares the answers with the snapshot
+// saved there, then with savePath, saves them. Without diffPath the answers are printed
+// as usual. It reports whether the answers are the same as in the snapshot.
+func snapshotDNS(domain string, opts digOptions, savePath, diffPath string) (bool, error) {
+	var old DNSResults
+	if diffPath != "" {
+		var err error
+		old, err = loadDNSSnapshot(diffPath)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	results, err := lookupDNS(domain, opts)
+	if err != nil {
+		return false, fmt.Errorf("querying %s records: %v", opts.queryType, err)
+	}
+	if opts.simple && opts.queryType == "" {
+		results = results.simplified()
+	}
+
+	same := true
+	if diffPath != "" {
+		if old.Domain != results.Domain {
+			return false, fmt.Errorf("snapshot %s is for %s, not %s", diffPath, old.Domain, results.Domain)
+		}
+		same = diffDNSSnapshot(diffPath, old, results)
+	} else {
+		printResults(results)
+	}
+
+	if savePath != "" {
+		if err := saveDNSSnapshot(savePath, results); err != nil {
+			return false, err
+		}
+	}
+	return same, nil
+}
@@ -0,0 +1,362 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeDNSServer is a nameserver on loopback answering from fixed maps of CNAME targets
+// and IPv4 addresses, one record per query as an authoritative server does
+type fakeDNSServer struct {
+	cnames map[string]string
+	addrs  map[string]string
+}
+
+// start serves the records until the test ends and returns the server's address
+func (f fakeDNSServer) start(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        conn,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			q := req.Question[0]
+			name := normalizeName(q.Name)
+			if target, ok := f.cnames[name]; ok {
+				rr, _ := dns.NewRR(fmt.Sprintf("%s 300 IN CNAME %s.", q.Name, target))
+				resp.Answer = append(resp.Answer, rr)
+			} else if addr, ok := f.addrs[name]; ok && q.Qtype == dns.TypeA {
+				rr, _ := dns.NewRR(fmt.Sprintf("%s 300 IN A %s", q.Name, addr))
+				resp.Answer = append(resp.Answer, rr)
+			}
+			w.WriteMsg(resp)
+		}),
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+// resolver returns the CNAME resolver querying the fake server
+func (f fakeDNSServer) resolver(t *testing.T) cnameResolver {
+	return dnsCNAMEResolver{query: dnsQuery{server: f.start(t), timeout: time.Second}}
+}
+
+func TestResolveCNAMEChain(t *testing.T) {
+	resolver := fakeDNSServer{cnames: map[string]string{
+		"www.example.com": "cdn.example.net",
+		"cdn.example.net": "edge.example.org",
+	}}.resolver(t)
+
+	chain, err := resolveCNAMEChain(context.Background(), resolver, "www.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"cdn.example.net.", "edge.example.org."}
+	if strings.Join(chain, ",") != strings.Join(want, ",") {
+		t.Errorf("chain = %v, want %v", chain, want)
+	}
+}
+
+func TestResolveCNAMEChain_NotAnAlias(t *testing.T) {
+	chain, err := resolveCNAMEChain(context.Background(), fakeDNSServer{}.resolver(t), "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chain) != 0 {
+		t.Errorf("chain = %v, want none", chain)
+	}
+}
+
+func TestResolveCNAMEChain_Loop(t *testing.T) {
+	resolver := fakeDNSServer{cnames: map[string]string{
+		"a.example.com": "b.example.com",
+		"b.example.com": "c.example.com",
+		"c.example.com": "a.example.com",
+	}}.resolver(t)
+
+	chain, err := resolveCNAMEChain(context.Background(), resolver, "a.example.com")
+	if err == nil || !strings.Contains(err.Error(), "CNAME loop detected") {
+		t.Fatalf("err = %v, want a CNAME loop error", err)
+	}
+	if len(chain) != 2 {
+		t.Errorf("chain = %v, want the two hops before the loop", chain)
+	}
+}
+
+func TestResolveCNAMEChain_TooLong(t *testing.T) {
+	cnames := map[string]string{}
+	for i := 0; i < 2*maxCNAMEChain; i++ {
+		cnames[fmt.Sprintf("h%d.example.com", i)] = fmt.Sprintf("h%d.example.com", i+1)
+	}
+	resolver := fakeDNSServer{cnames: cnames}.resolver(t)
+
+	chain, err := resolveCNAMEChain(context.Background(), resolver, "h0.example.com")
+	if err == nil {
+		t.Fatal("expected an error for an overlong chain")
+	}
+	if len(chain) != maxCNAMEChain {
+		t.Errorf("chain has %d hops, want %d", len(chain), maxCNAMEChain)
+	}
+}
+
+func TestChainHops(t *testing.T) {
+	server := fakeDNSServer{
+		cnames: map[string]string{
+			"www.example.com": "cdn.example.net",
+			"cdn.example.net": "edge.example.org",
+		},
+		addrs: map[string]string{"edge.example.org": "192.0.2.1"},
+	}.start(t)
+
+	hops, err := chainHops("www.example.com", digOptions{server: server, timeout: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "www.example.com → cdn.example.net → edge.example.org → 192.0.2.1"
+	if got := strings.Join(hops, " → "); got != want {
+		t.Errorf("chain = %q, want %q", got, want)
+	}
+}
+
+func TestResponseStatus(t *testing.T) {
+	a, err := dns.NewRR("example.com. 300 IN A 93.184.216.34")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rcode   int
+		answers []dns.RR
+		want    string
+	}{
+		{dns.RcodeSuccess, []dns.RR{a}, "NOERROR"},
+		{dns.RcodeSuccess, nil, "NODATA"},
+		{dns.RcodeNameError, nil, "NXDOMAIN"},
+		{dns.RcodeServerFailure, nil, "SERVFAIL"},
+		{dns.RcodeRefused, nil, "REFUSED"},
+	}
+	for _, tt := range tests {
+		resp := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: tt.rcode}, Answer: tt.answers}
+		if got := responseStatus(resp); got != tt.want {
+			t.Errorf("responseStatus(rcode %d, %d answers) = %s, want %s", tt.rcode, len(tt.answers), got, tt.want)
+		}
+	}
+}
+
+func TestParseSecurityVerdict(t *testing.T) {
+	result, err := parseSecurityVerdict("192.0.2.1 is GREAT: 26 queries in 1.7 seconds from 26 ports with std dev 17685.58")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.rating != "GREAT" || result.queries != 26 || result.unique != 26 || result.stdDev != 17685.58 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if !result.passed() {
+		t.Error("GREAT should pass")
+	}
+
+	result, err = parseSecurityVerdict("192.0.2.1 is POOR: 26 queries in 1.2 seconds from 1 txids with std dev 0.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.passed() || result.entropyBits() != 0 {
+		t.Errorf("POOR with no spread should warn with zero entropy, got %+v", result)
+	}
+
+	if _, err := parseSecurityVerdict("something else"); err == nil {
+		t.Error("expected an error for an unrecognized answer")
+	}
+}
+
+func TestDNSCacheExpiry(t *testing.T) {
+	cache, err := loadDNSCache(filepath.Join(t.TempDir(), "cache.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := new(dns.Msg)
+	resp.SetQuestion("example.com.", dns.TypeA)
+	a, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+	b, _ := dns.NewRR("example.com. 60 IN A 192.0.2.2")
+	resp.Answer = []dns.RR{a, b}
+
+	now := time.Now()
+	if err := cache.put("key", resp, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloading reads the entry back from disk, aged by the time since it was stored
+	cache, err = loadDNSCache(cache.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, ok := cache.get("key", now.Add(10*time.Second))
+	if !ok {
+		t.Fatal("expected a cache hit")
+	}
+	if ttl := got.Answer[0].Header().Ttl; ttl != 290 {
+		t.Errorf("TTL after 10s = %d, want 290", ttl)
+	}
+
+	// The entry expires with the lowest TTL
+	if _, _, ok := cache.get("key", now.Add(60*time.Second)); ok {
+		t.Error("expected the entry to expire after 60s")
+	}
+
+	// SERVFAIL has no TTL and is not stored
+	resp.Rcode = dns.RcodeServerFailure
+	resp.Answer = nil
+	if err := cache.put("fail", resp, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.get("fail", now); ok {
+		t.Error("SERVFAIL should not be cached")
+	}
+}
+
+func TestIDNConversion(t *testing.T) {
+	tests := []struct {
+		unicode, ascii string
+	}{
+		{"bücher.example.", "xn--bcher-kva.example."},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"example.com", "example.com"},
+		{"_dmarc.example.com", "_dmarc.example.com"},
+		{"_sip._tcp.bücher.example", "_sip._tcp.xn--bcher-kva.example"},
+		{"*.example.com", "*.example.com"},
+	}
+	for _, tt := range tests {
+		ascii, err := idnToASCII(tt.unicode)
+		if err != nil || ascii != tt.ascii {
+			t.Errorf("idnToASCII(%q) = %q, %v; want %q", tt.unicode, ascii, err, tt.ascii)
+		}
+		if got := idnToUnicode(tt.ascii); got != tt.unicode {
+			t.Errorf("idnToUnicode(%q) = %q, want %q", tt.ascii, got, tt.unicode)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var rtts []time.Duration
+	for i := 1; i <= 10; i++ {
+		rtts = append(rtts, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{99, 10 * time.Millisecond},
+		{0, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(rtts, tt.p); got != tt.want {
+			t.Errorf("percentile(p%v) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestMostCommonAnswer(t *testing.T) {
+	keys := []string{"NOERROR 192.0.2.1", "NOERROR 192.0.2.2", "NOERROR 192.0.2.2", "TIMEOUT "}
+	common, distinct := mostCommonAnswer(keys)
+	if common != "NOERROR 192.0.2.2" || distinct != 3 {
+		t.Errorf("mostCommonAnswer = %q, %d; want %q, 3", common, distinct, "NOERROR 192.0.2.2")
+	}
+
+	// On a tie, the first answer seen wins
+	if common, _ := mostCommonAnswer([]string{"b", "a", "a", "b"}); common != "b" {
+		t.Errorf("mostCommonAnswer on a tie = %q, want %q", common, "b")
+	}
+}
+
+func TestParseClientSubnet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"198.51.100.0/24", "198.51.100.0/24"},
+		{"198.51.100.77/24", "198.51.100.0/24"},
+		{"192.0.2.1", "192.0.2.1/32"},
+		{"2001:db8::/56", "2001:db8::/56"},
+	}
+	for _, tt := range tests {
+		subnet, err := parseClientSubnet(tt.in)
+		if err != nil || subnet.String() != tt.want {
+			t.Errorf("parseClientSubnet(%q) = %v, %v; want %s", tt.in, subnet, err, tt.want)
+		}
+	}
+	if _, err := parseClientSubnet("example.com"); err == nil {
+		t.Error("expected an error for a host name")
+	}
+
+	option := clientSubnetOption(&net.IPNet{IP: net.ParseIP("198.51.100.0"), Mask: net.CIDRMask(24, 32)})
+	if option.Family != 1 || option.SourceNetmask != 24 || len(option.Address) != net.IPv4len {
+		t.Errorf("clientSubnetOption = %+v, want family 1, netmask 24 and a 4-byte address", option)
+	}
+}
+
+func TestSnapshotRecordSets(t *testing.T) {
+	old := DNSResults{Domain: "example.com", Status: "NOERROR", A: []string{"192.0.2.2", "192.0.2.1"},
+		MX:    []MXRecord{{Host: "mx.example.com.", Priority: 10}},
+		Other: []string{"example.com.\t3600\tIN\tCAA\t0 issue \"letsencrypt.org\""}}
+	// The same answers in another order with a lower TTL, one address swapped and an HTTPS record added
+	current := DNSResults{Domain: "example.com", Status: "NOERROR", A: []string{"192.0.2.3", "192.0.2.1"},
+		MX:    []MXRecord{{Host: "mx.example.com.", Priority: 10}},
+		Other: []string{"example.com.\t1742\tIN\tCAA\t0 issue \"letsencrypt.org\""},
+		HTTPS: []SVCBRecord{{Priority: 1, Target: ".", Params: map[string]string{"port": "443", "alpn": "h2"}}}}
+
+	oldSets, sets := snapshotRecordSets(old), snapshotRecordSets(current)
+	changed := map[string]string{}
+	for i, set := range sets {
+		removed, added := diffRecordSets(oldSets[i].records, set.records)
+		if len(removed) > 0 || len(added) > 0 {
+			changed[set.name] = fmt.Sprintf("-%v +%v", removed, added)
+		}
+	}
+	want := map[string]string{"A": "-[192.0.2.2] +[192.0.2.3]", "HTTPS": "-[] +[1 . alpn=h2 port=443]"}
+	if fmt.Sprint(changed) != fmt.Sprint(want) {
+		t.Errorf("changed record sets = %v, want %v", changed, want)
+	}
+}
+
+func TestRecordMatches(t *testing.T) {
+	tests := []struct {
+		recordType, record, value string
+		want                      bool
+	}{
+		{"A", "93.184.216.34", "93.184.216.34", true},
+		{"A", "93.184.216.34", "1.2.3.4", false},
+		{"AAAA", "2001:db8::1", "2001:db8:0:0::1", true},
+		{"CNAME", "cdn.example.net.", "CDN.example.net", true},
+		{"MX", "10 mail.example.com.", "mail.example.com", true},
+		{"MX", "10 mail.example.com.", "10 mail.example.com", true},
+		{"MX", "10 mail.example.com.", "20 mail.example.com", false},
+		{"NS", "ns1.example.com.", "ns2.example.com", false},
+		{"TXT", "v=spf1 -all", "v=spf1 -all", true},
+		{"TXT", "v=spf1 -all", "V=SPF1 -all", false},
+	}
+	for _, tt := range tests {
+		if got := recordMatches(tt.recordType, tt.record, tt.value); got != tt.want {
+			t.Errorf("recordMatches(%s, %q, %q) = %v, want %v", tt.recordType, tt.record, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// resolvConfPath is the resolver configuration consulted for the default nameserver
+var resolvConfPath = "/etc/resolv.conf"
+
+// dnsQuery describes a single question sent through the raw-query resolver, which
+// talks DNS on the wire instead of going through the system's stub resolver
+type dnsQuery struct {
+	server  string // host:port of the nameserver; the system's first nameserver if empty
+	name    string
+	qtype   uint16
+	qclass  uint16 // dns.ClassINET if zero
+	bufsize uint16 // EDNS UDP buffer size to advertise; 0 sends the query without EDNS
+	timeout time.Duration
+	cache   *dnsCache  // serves and stores responses until their TTLs expire, if set
+	source  string     // local IP address to send the query from; chosen by the kernel if empty
+	ecs     *net.IPNet // client subnet sent in an EDNS Client Subnet option, if set; needs bufsize
+}
+
+// exchangeInfo describes how a response was received
+type exchangeInfo struct {
+	rtt    time.Duration
+	size   int  // length of the response on the wire, in bytes
+	cached bool // served from the cache instead of the server
+	ecs    bool // the query carried an EDNS Client Subnet option
+}
+
+// exchange sends the query and returns the response along with how it was received
+func (q dnsQuery) exchange() (*dns.Msg, exchangeInfo, error) {
+	server := q.server
+	if server == "" {
+		var err error
+		server, err = defaultNameserver()
+		if err != nil {
+			return nil, exchangeInfo{}, err
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(q.name), q.qtype)
+	if q.qclass != 0 {
+		msg.Question[0].Qclass = q.qclass
+	}
+	if q.bufsize > 0 {
+		msg.SetEdns0(q.bufsize, false)
+		if q.ecs != nil {
+			opt := msg.IsEdns0()
+			opt.Option = append(opt.Option, clientSubnetOption(q.ecs))
+		}
+	}
+
+	// A cached answer to the same question from the same server is still good
+	var key string
+	if q.cache != nil {
+		key = dnsCacheKey(msg, server)
+		if resp, size, ok := q.cache.get(key, time.Now()); ok {
+			return resp, exchangeInfo{size: size, cached: true, ecs: q.ecs != nil}, nil
+		}
+	}
+
+	resp, info, err := exchangeWire(q.client("udp"), msg, server)
+	if err != nil {
+		if q.source != "" {
+			err = sourceBindError(q.source, server, err)
+		}
+		return nil, exchangeInfo{}, fmt.Errorf("query to %s failed: %w", server, err)
+	}
+
+	// Retry over TCP when the answer did not fit in a UDP datagram
+	if resp.Truncated {
+		resp, info, err = exchangeWire(q.client("tcp"), msg, server)
+		if err != nil {
+			if q.source != "" {
+				err = sourceBindError(q.source, server, err)
+			}
+			return nil, exchangeInfo{}, fmt.Errorf("TCP query to %s failed: %w", server, err)
+		}
+	}
+
+	if q.cache != nil {
+		if err := q.cache.put(key, resp, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	info.ecs = q.ecs != nil
+	return resp, info, nil
+}
+
+// clientSubnetOption builds the EDNS Client Subnet option (RFC 7871) asking the server
+// to answer as it would for clients in subnet
+func clientSubnetOption(subnet *net.IPNet) *dns.EDNS0_SUBNET {
+	ones, _ := subnet.Mask.Size()
+	option := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, SourceNetmask: uint8(ones)}
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		option.Family, option.Address = 1, ip4
+	} else {
+		option.Family, option.Address = 2, subnet.IP
+	}
+	return option
+}
+
+// parseClientSubnet parses an --ecs subnet such as 198.51.100.0/24; a bare address
+// stands for itself alone (/32 or /128)
+func parseClientSubnet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, subnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client subnet %q (use e.g. 198.51.100.0/24)", s)
+	}
+	return subnet, nil
+}
+
+// client returns a DNS client for the network ("udp" or "tcp") whose sockets are bound
+// to the query's source address, if it has one
+func (q dnsQuery) client(network string) *dns.Client {
+	client := &dns.Client{Net: network, Timeout: q.timeout}
+	if q.source != "" {
+		client.Dialer = &net.Dialer{Timeout: q.timeout, LocalAddr: sourceAddr(network, q.source)}
+	}
+	return client
+}
+
+// sourceAddr returns the local address for a socket of the network bound to the source
+// IP address, or nil to let the kernel pick one
+func sourceAddr(network, source string) net.Addr {
+	if source == "" {
+		return nil
+	}
+	addr, zone := splitZone(source)
+	ip := net.ParseIP(addr)
+	if strings.HasPrefix(network, "tcp") {
+		return &net.TCPAddr{IP: ip, Zone: zone}
+	}
+	return &net.UDPAddr{IP: ip, Zone: zone}
+}
+
+// sourceBindError explains a failure to open a socket from the source address to the
+// server, which is usually down to the two being of different IP families
+func sourceBindError(source, server string, err error) error {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" {
+		return err
+	}
+	host, _, _ := net.SplitHostPort(server)
+	if dst := net.ParseIP(host); dst != nil && (dst.To4() == nil) != (net.ParseIP(source).To4() == nil) {
+		return fmt.Errorf("cannot send from %s to %s: the addresses are of different IP families", source, host)
+	}
+	return fmt.Errorf("cannot send queries from %s: %v", source, opErr.Err)
+}
+
+// exchangeWire sends msg and reads the raw reply itself, unlike dns.Client.Exchange, so
+// that the size of the response on the wire is known
+func exchangeWire(client *dns.Client, msg *dns.Msg, server string) (*dns.Msg, exchangeInfo, error) {
+	conn, err := client.Dial(server)
+	if err != nil {
+		return nil, exchangeInfo{}, err
+	}
+	defer conn.Close()
+
+	// Read datagrams up to the buffer size the query advertised
+	if opt := msg.IsEdns0(); opt != nil {
+		conn.UDPSize = opt.UDPSize()
+	}
+	if client.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(client.Timeout))
+	}
+
+	start := time.Now()
+	if err := conn.WriteMsg(msg); err != nil {
+		return nil, exchangeInfo{}, err
+	}
+	for {
+		raw, err := conn.ReadMsgHeader(nil)
+		if err != nil {
+			return nil, exchangeInfo{}, err
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(raw); err != nil {
+			return nil, exchangeInfo{}, err
+		}
+		// Late replies to an earlier query may still arrive over UDP
+		if resp.Id != msg.Id {
+			continue
+		}
+		return resp, exchangeInfo{rtt: time.Since(start), size: len(raw)}, nil
+	}
+}
+
+// transferStats summarizes a zone transfer
+type transferStats struct {
+	messages int
+	bytes    int
+}
+
+// transfer requests a full zone transfer (AXFR) over TCP and returns the records of the
+// zone, starting and ending with its SOA record. The answer may span several messages.
+func (q dnsQuery) transfer() ([]dns.RR, transferStats, error) {
+	var stats transferStats
+	server := q.server
+	if server == "" {
+		var err error
+		server, err = defaultNameserver()
+		if err != nil {
+			return nil, stats, err
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetAxfr(dns.Fqdn(q.name))
+
+	conn, err := q.client("tcp").Dial(server)
+	if err != nil {
+		if q.source != "" {
+			err = sourceBindError(q.source, server, err)
+		}
+		return nil, stats, fmt.Errorf("failed to connect to %s: %v", server, err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMsg(msg); err != nil {
+		return nil, stats, fmt.Errorf("failed to send AXFR query: %v", err)
+	}
+
+	var records []dns.RR
+	for {
+		if q.timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(q.timeout))
+		}
+		raw, err := conn.ReadMsgHeader(nil)
+		if err != nil {
+			return records, stats, fmt.Errorf("transfer from %s failed after %d records: %v", server, len(records), err)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(raw); err != nil {
+			return records, stats, fmt.Errorf("malformed transfer message: %v", err)
+		}
+		if resp.Id != msg.Id {
+			return records, stats, fmt.Errorf("transfer message with unexpected ID %d", resp.Id)
+		}
+		stats.messages++
+		stats.bytes += len(raw)
+
+		switch resp.Rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeRefused, dns.RcodeNotAuth:
+			return nil, stats, fmt.Errorf("transfer refused by %s (%s)", server, dns.RcodeToString[resp.Rcode])
+		default:
+			return nil, stats, fmt.Errorf("transfer failed: %s", dns.RcodeToString[resp.Rcode])
+		}
+
+		for _, rr := range resp.Answer {
+			isSOA := rr.Header().Rrtype == dns.TypeSOA
+			if len(records) == 0 && !isSOA {
+				return nil, stats, fmt.Errorf("transfer from %s does not start with an SOA record", server)
+			}
+			records = append(records, rr)
+			// The zone's SOA record is repeated to mark the end of the transfer
+			if isSOA && len(records) > 1 {
+				return records, stats, nil
+			}
+		}
+	}
+}
+
+// printMsgSize prints the wire size of a response and its EDNS parameters as dig's
+// ";;" comment lines
+func printMsgSize(w io.Writer, resp *dns.Msg, info exchangeInfo) {
+	if opt := resp.IsEdns0(); opt != nil {
+		fmt.Fprintf(w, ";; EDNS: version %d, udp: %d\n", opt.Version(), opt.UDPSize())
+		// The scope says how widely the answer applies: 0 means to everyone, a prefix as
+		// long as the source means to that subnet only
+		var subnet *dns.EDNS0_SUBNET
+		for _, option := range opt.Option {
+			if o, ok := option.(*dns.EDNS0_SUBNET); ok {
+				subnet = o
+			}
+		}
+		switch {
+		case subnet != nil:
+			fmt.Fprintf(w, ";; CLIENT-SUBNET: %s/%d, scope /%d\n", subnet.Address, subnet.SourceNetmask, subnet.SourceScope)
+		case info.ecs:
+			fmt.Fprintln(w, ";; CLIENT-SUBNET: not returned (the server ignores the option)")
+		}
+	} else {
+		fmt.Fprintln(w, ";; EDNS: not used")
+	}
+	if info.cached {
+		fmt.Fprintf(w, ";; MSG SIZE rcvd: %d (cached)\n", info.size)
+		return
+	}
+	fmt.Fprintf(w, ";; MSG SIZE rcvd: %d\n", info.size)
+}
+
+// defaultNameserver returns the first nameserver configured in resolv.conf as host:port
+func defaultNameserver() (string, error) {
+	config, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read nameservers from %s: %v", resolvConfPath, err)
+	}
+	if len(config.Servers) == 0 {
+		return "", fmt.Errorf("no nameservers configured in %s", resolvConfPath)
+	}
+	return net.JoinHostPort(config.Servers[0], config.Port), nil
+}
+
+// nameserverAddress normalizes a nameserver given as "host", "host:port" or an IPv6
+// literal into a host:port address, defaulting to port 53
+func nameserverAddress(server string) string {
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), "53")
+	}
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "53")
+}
+
+// rdataString returns the presentation form of a record's data, without the owner name,
+// TTL, class and type, so records can be compared across responses
+func rdataString(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// responseStatus returns the outcome of a raw query as dig's status: the rcode name, or
+// NODATA when the name exists but has no records of the queried type
+func responseStatus(resp *dns.Msg) string {
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0 {
+		return "NODATA"
+	}
+	status, ok := dns.RcodeToString[resp.Rcode]
+	if !ok {
+		return fmt.Sprintf("RCODE%d", resp.Rcode)
+	}
+	return status
+}
+
+// parseQueryType converts a record type name such as "HTTPS" or "mx" to its numeric code
+func parseQueryType(name string) (uint16, error) {
+	qtype, ok := dns.StringToType[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown record type %q", name)
+	}
+	return qtype, nil
+}
+
+// addAnswers decodes the answer section of a raw response into the DNSResults fields
+func addAnswers(results *DNSResults, answers []dns.RR) {
+	for _, rr := range answers {
+		switch record := rr.(type) {
+		case *dns.A:
+			results.A = append(results.A, record.A.String())
+		case *dns.AAAA:
+			results.AAAA = append(results.AAAA, record.AAAA.String())
+		case *dns.CNAME:
+			results.CNAME = append(results.CNAME, record.Target)
+		case *dns.MX:
+			results.MX = append(results.MX, MXRecord{Host: record.Mx, Priority: record.Preference})
+		case *dns.NS:
+			results.NS = append(results.NS, record.Ns)
+		case *dns.TXT:
+			results.TXT = append(results.TXT, strings.Join(record.Txt, ""))
+		case *dns.HTTPS:
+			results.HTTPS = append(results.HTTPS, newSVCBRecord(&record.SVCB))
+		case *dns.SVCB:
+			results.SVCB = append(results.SVCB, newSVCBRecord(record))
+		default:
+			// Keep record types without a dedicated field in presentation format
+			results.Other = append(results.Other, rr.String())
+		}
+	}
+}
+
+// newSVCBRecord converts an SVCB (or HTTPS) resource record to its output form
+func newSVCBRecord(rr *dns.SVCB) SVCBRecord {
+	record := SVCBRecord{
+		Priority: rr.Priority,
+		Target:   rr.Target,
+	}
+	if len(rr.Value) > 0 {
+		record.Params = make(map[string]string, len(rr.Value))
+		for _, kv := range rr.Value {
+			record.Params[kv.Key().String()] = kv.String()
+		}
+	}
+	return record
+}
@@ -0,0 +1,229 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	probing "github.com/prometheus-community/pro-bing"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Run quick checks of the local network and summarize its health",
+	Long: `Doctor runs a battery of quick checks: which interfaces are up, whether the default
+gateway answers pings, whether DNS resolves a well-known name and whether an outbound HTTP
+request succeeds. Each check is reported as passed or failed, and the command exits non-zero
+when any check fails. Use --output json for automation.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		host, _ := cmd.Flags().GetString("host")
+		urlStr, _ := cmd.Flags().GetString("url")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		if output != "text" && output != "json" {
+			fatalf("Error executing doctor: unsupported output format %q (use text or json)", output)
+		}
+
+		opts := doctorOptions{
+			host:    host,
+			url:     urlStr,
+			timeout: timeout,
+		}
+		checks := runDoctorChecks(opts)
+
+		if output == "json" {
+			data, err := json.MarshalIndent(checks, "", "  ")
+			if err != nil {
+				fatalf("Error executing doctor: %v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			printDoctorChecks(checks)
+		}
+
+		failed := 0
+		for _, check := range checks {
+			if !check.OK {
+				failed++
+			}
+		}
+		if failed > 0 {
+			cmd.SilenceUsage = true
+			return &exitError{code: 1, err: fmt.Errorf("%d of %d checks failed", failed, len(checks))}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringP("output", "o", "text", "Output format: text or json")
+	doctorCmd.Flags().String("host", "example.com", "Name resolved by the DNS check")
+	doctorCmd.Flags().String("url", "https://example.com", "URL fetched by the HTTP check")
+	doctorCmd.Flags().DurationP("timeout", "t", 3*time.Second, "Time limit for each check")
+}
+
+// doctorOptions holds the settings collected from the doctor command's flags
+type doctorOptions struct {
+	host    string
+	url     string
+	timeout time.Duration
+}
+
+// doctorCheck is the outcome of one check
+type doctorCheck struct {
+	Name     string  `json:"name"`
+	OK       bool    `json:"ok"`
+	Detail   string  `json:"detail"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// runDoctorChecks runs every check in turn and returns their outcomes
+func runDoctorChecks(opts doctorOptions) []doctorCheck {
+	checks := []struct {
+		name string
+		run  func(doctorOptions) (string, error)
+	}{
+		{"interfaces", checkInterfaces},
+		{"gateway", checkGateway},
+		{"dns", checkDNS},
+		{"http", checkHTTP},
+	}
+
+	var results []doctorCheck
+	for _, check := range checks {
+		start := time.Now()
+		detail, err := check.run(opts)
+		result := doctorCheck{
+			Name:     check.name,
+			OK:       err == nil,
+			Detail:   detail,
+			Duration: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			result.Detail = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkInterfaces lists the interfaces that are up, other than loopback
+func checkInterfaces(opts doctorOptions) (string, error) {
+	interfaces, err := getInterfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list interfaces: %v", err)
+	}
+	var up []string
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+			up = append(up, iface.Name)
+		}
+	}
+	if len(up) == 0 {
+		return "", fmt.Errorf("no interfaces are up besides loopback")
+	}
+	return strings.Join(up, ", ") + " up", nil
+}
+
+// checkGateway pings the default gateway
+func checkGateway(opts doctorOptions) (string, error) {
+	gateway, iface, err := defaultGateway()
+	if err != nil {
+		return "", err
+	}
+
+	pinger, err := probing.NewPinger(gateway.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to create pinger: %v", err)
+	}
+	pinger.Count = 3
+	pinger.Interval = minUnprivilegedInterval
+	pinger.Timeout = opts.timeout
+	pinger.SetPrivileged(rawICMPAllowed())
+	if err := pinger.Run(); err != nil {
+		return "", fmt.Errorf("failed to ping gateway %s: %v", gateway, err)
+	}
+
+	stats := pinger.Statistics()
+	if stats.PacketsRecv == 0 {
+		return "", fmt.Errorf("gateway %s via %s did not answer %d pings", gateway, iface, stats.PacketsSent)
+	}
+	return fmt.Sprintf("%s via %s, %d/%d replies, avg %.3f ms", gateway, iface,
+		stats.PacketsRecv, stats.PacketsSent, stats.AvgRtt.Seconds()*1000), nil
+}
+
+// checkDNS resolves the --host name with the system resolver
+func checkDNS(opts doctorOptions) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, opts.host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", opts.host, err)
+	}
+	return fmt.Sprintf("%s resolves to %s", opts.host, strings.Join(addrs, ", ")), nil
+}
+
+// checkHTTP fetches the --url with the same client curl uses; any response counts, as
+// it shows the request made it out and back
+func checkHTTP(opts doctorOptions) (string, error) {
+	curlOpts := curlOptions{timeout: opts.timeout}
+	client, err := newCurlClient(opts.url, curlOpts)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", opts.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+	if err := setRequestHeaders(req, curlOpts); err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request to %s failed: %v", opts.url, err)
+	}
+	resp.Body.Close()
+	return fmt.Sprintf("GET %s: %s", opts.url, resp.Status), nil
+}
+
+// printDoctorChecks prints one line per check and a summary, colored on a terminal
+func printDoctorChecks(checks []doctorCheck) {
+	color := term.IsTerminal(int(os.Stdout.Fd()))
+	mark := func(ok bool) string {
+		switch {
+		case ok && color:
+			return "\033[32m✔\033[0m"
+		case ok:
+			return "✔"
+		case color:
+			return "\033[31m✘\033[0m"
+		default:
+			return "✘"
+		}
+	}
+
+	passed := 0
+	for _, check := range checks {
+		if check.OK {
+			passed++
+		}
+		fmt.Printf("%s %-10s %s (%.0f ms)\n", mark(check.OK), check.Name, check.Detail, check.Duration)
+	}
+	fmt.Printf("\n%s %d of %d checks passed\n", mark(passed == len(checks)), passed, len(checks))
+}
@@ -0,0 +1,45 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// defaultGateway returns the IPv4 default gateway and its interface from /proc/net/route
+func defaultGateway() (net.IP, string, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read routing table: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header line
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// The default route has an all-zero destination and mask, and a gateway
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" || fields[2] == "00000000" {
+			continue
+		}
+		// Addresses are hex in host byte order, as in /proc/net/tcp
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		gateway := make(net.IP, 4)
+		binary.BigEndian.PutUint32(gateway, binary.LittleEndian.Uint32(raw))
+		return gateway, fields[0], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read routing table: %v", err)
+	}
+	return nil, "", fmt.Errorf("no default route")
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+)
+
+// defaultGateway is only implemented on Linux, where /proc/net/route lists the routes
+func defaultGateway() (net.IP, string, error) {
+	return nil, "", fmt.Errorf("finding the default gateway is only supported on Linux")
+}
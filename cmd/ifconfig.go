@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -17,11 +18,48 @@ var getInterfaceByName = net.InterfaceByName
 
 // ifconfigCmd represents the ifconfig command
 var ifconfigCmd = &cobra.Command{
-	Use:   "ifconfig [interface name]",
+	Use:   "ifconfig [interface name] [add|del address/prefix]",
 	Short: "Displays network interface information",
-	Long:  `Displays network interface details. You can provide an interface name to show details of that specific interface, or leave it empty to show details for all interfaces.`,
-	Args:  cobra.MaximumNArgs(1), // Allows 0 or 1 argument
+	Long: `Displays network interface details. You can provide an interface name to show details of that specific interface, or leave it empty to show details for all interfaces.
+IPv6 addresses are annotated with their scope (link-local, unique-local, global); link-local addresses include the zone, e.g. fe80::1%eth0.
+With --monitor, the receive and transmit rates are sampled every --interval and shown until Ctrl-C.
+On Linux, "netro ifconfig eth0 add 192.168.1.10/24" assigns an address to an interface and
+"netro ifconfig eth0 del 192.168.1.10/24" removes it; both need root (or CAP_NET_ADMIN).`,
+	Args: cobra.MaximumNArgs(3), // An interface name, optionally followed by add/del and an address
 	Run: func(cmd *cobra.Command, args []string) {
+		monitor, _ := cmd.Flags().GetBool("monitor")
+		interval, _ := cmd.Flags().GetDuration("interval")
+
+		// Change the interface's addresses instead of showing them
+		if len(args) > 1 {
+			verb, ip, prefix, err := parseAddressChange(args[1:])
+			if err == nil {
+				err = changeInterfaceAddress(args[0], verb, ip, prefix)
+			}
+			if err != nil {
+				fatalf("Error executing ifconfig: %v", err)
+			}
+			ones, _ := prefix.Mask.Size()
+			if verb == "add" {
+				fmt.Printf("Added %s/%d to %s\n", ip, ones, args[0])
+			} else {
+				fmt.Printf("Removed %s/%d from %s\n", ip, ones, args[0])
+			}
+			return
+		}
+
+		// Show live throughput instead of the interface details
+		if monitor {
+			var name string
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if err := monitorInterfaces(name, interval); err != nil {
+				fatalf("Error executing ifconfig: %v", err)
+			}
+			return
+		}
+
 		// If an interface name is provided, filter by that name
 		if len(args) == 1 {
 			interfaceName := args[0]
@@ -35,6 +73,21 @@ var ifconfigCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(ifconfigCmd)
+	ifconfigCmd.Flags().Bool("monitor", false, "Continuously show RX/TX rates (bytes and packets per second)")
+	ifconfigCmd.Flags().Duration("interval", time.Second, "Sampling interval for --monitor")
+}
+
+// parseAddressChange parses the "add|del address/prefix" arguments that follow the
+// interface name. The prefix length is required, as it sets the connected route.
+func parseAddressChange(args []string) (string, net.IP, *net.IPNet, error) {
+	if len(args) != 2 || (args[0] != "add" && args[0] != "del") {
+		return "", nil, nil, fmt.Errorf("expected \"add <address/prefix>\" or \"del <address/prefix>\" after the interface name")
+	}
+	ip, prefix, err := net.ParseCIDR(args[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid address %q: use CIDR notation such as 192.168.1.10/24 or 2001:db8::10/64", args[1])
+	}
+	return args[0], ip, prefix, nil
 }
 
 // Function to show details of a specific interface
@@ -57,7 +110,7 @@ func showAllInterfacesDetails() {
 	interfaces, err := getInterfaces()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error fetching interfaces: %v\n", err)
-		os.Exit(1)
+		exitCommand(1, err)
 	}
 
 	// Check if there are any interfaces
@@ -101,7 +154,18 @@ func printInterfaceDetails(iface *net.Interface) {
 		fmt.Println("  IP Addresses:")
 		for _, addr := range addrs {
 			ipNet, ok := addr.(*net.IPNet)
-			if ok {
+			if ok && ipNet.IP.To4() == nil {
+				// IPv6 addresses are shown with their scope; link-local ones only make
+				// sense together with the interface, so they carry it as the zone
+				scope := ipv6Scope(ipNet.IP)
+				ip := ipNet.IP.String()
+				if scope == "link-local" {
+					ip += "%" + iface.Name
+				}
+				fmt.Printf("    - IP Address: %s\n", ip)
+				fmt.Printf("      Netmask: %s\n", net.IP(ipNet.Mask).String())
+				fmt.Printf("      Scope: %s\n", scope)
+			} else if ok {
 				// Print the IP address
 				fmt.Printf("    - IP Address: %s\n", ipNet.IP.String())
 
@@ -118,3 +182,22 @@ func printInterfaceDetails(iface *net.Interface) {
 
 	fmt.Println() // Add extra line for better readability
 }
+
+// ipv6Scope names the scope of an IPv6 address: link-local (fe80::/10), unique-local
+// (fc00::/7), global, loopback or multicast
+func ipv6Scope(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case ip.IsPrivate():
+		return "unique-local"
+	case ip.IsMulticast():
+		return "multicast"
+	case ip.IsGlobalUnicast():
+		return "global"
+	default:
+		return "unspecified"
+	}
+}
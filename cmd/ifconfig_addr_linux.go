@@ -0,0 +1,115 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// changeInterfaceAddress adds or removes an address on the interface with an
+// RTM_NEWADDR or RTM_DELADDR request over rtnetlink, as "ip addr add/del" does
+func changeInterfaceAddress(name, verb string, ip net.IP, prefix *net.IPNet) error {
+	iface, err := getInterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("no such interface %s", name)
+	}
+
+	family, addr := unix.AF_INET6, ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		family, addr = unix.AF_INET, ip4
+	}
+	ones, _ := prefix.Mask.Size()
+
+	msgType, flags := uint16(unix.RTM_DELADDR), uint16(unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	if verb == "add" {
+		msgType = unix.RTM_NEWADDR
+		flags |= unix.NLM_F_CREATE | unix.NLM_F_EXCL
+	}
+
+	// struct ifaddrmsg followed by the IFA_LOCAL and IFA_ADDRESS attributes; on a
+	// broadcast or point-to-point link both are the interface's own address
+	body := make([]byte, unix.SizeofIfAddrmsg)
+	body[0] = byte(family)
+	body[1] = byte(ones)
+	binary.NativeEndian.PutUint32(body[4:], uint32(iface.Index))
+	body = appendRtAttr(body, unix.IFA_LOCAL, addr)
+	body = appendRtAttr(body, unix.IFA_ADDRESS, addr)
+
+	req := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	binary.NativeEndian.PutUint32(req[0:], uint32(unix.NLMSG_HDRLEN+len(body)))
+	binary.NativeEndian.PutUint16(req[4:], msgType)
+	binary.NativeEndian.PutUint16(req[6:], flags)
+	binary.NativeEndian.PutUint32(req[8:], 1) // sequence number
+	req = append(req, body...)
+
+	err = rtnetlinkRequest(req)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES):
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("permission denied: changing interface addresses requires root (or CAP_NET_ADMIN)")
+		}
+		return fmt.Errorf("permission denied: %v", err)
+	case verb == "add" && errors.Is(err, unix.EEXIST):
+		return fmt.Errorf("%s is already assigned to %s", ip, name)
+	case verb == "del" && errors.Is(err, unix.EADDRNOTAVAIL):
+		return fmt.Errorf("%s/%d is not assigned to %s", ip, ones, name)
+	default:
+		return fmt.Errorf("failed to %s address: %v", verb, err)
+	}
+}
+
+// appendRtAttr appends a struct rtattr with its payload, padded to the 4-byte alignment
+func appendRtAttr(b []byte, attrType uint16, payload []byte) []byte {
+	attr := make([]byte, unix.SizeofRtAttr, unix.SizeofRtAttr+len(payload)+unix.RTA_ALIGNTO)
+	binary.NativeEndian.PutUint16(attr[0:], uint16(unix.SizeofRtAttr+len(payload)))
+	binary.NativeEndian.PutUint16(attr[2:], attrType)
+	attr = append(attr, payload...)
+	for len(attr)%unix.RTA_ALIGNTO != 0 {
+		attr = append(attr, 0)
+	}
+	return append(b, attr...)
+}
+
+// rtnetlinkRequest sends a request to the kernel's routing socket and waits for its
+// acknowledgement, returning the errno the kernel reports
+func rtnetlinkRequest(req []byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.Header.Type != unix.NLMSG_ERROR || len(msg.Data) < 4 {
+				continue
+			}
+			if errno := -int32(binary.NativeEndian.Uint32(msg.Data)); errno != 0 {
+				return syscall.Errno(errno)
+			}
+			return nil
+		}
+	}
+}
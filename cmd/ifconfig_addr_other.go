@@ -0,0 +1,17 @@
+//go:build !linux
+
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+)
+
+// changeInterfaceAddress is only implemented on Linux, where it uses rtnetlink
+func changeInterfaceAddress(name, verb string, ip net.IP, prefix *net.IPNet) error {
+	return fmt.Errorf("adding and removing addresses is only supported on Linux")
+}
@@ -0,0 +1,27 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import "testing"
+
+func TestParseAddressChange(t *testing.T) {
+	verb, ip, prefix, err := parseAddressChange([]string{"add", "192.168.1.10/24"})
+	if err != nil {
+		t.Fatalf("parseAddressChange returned an unexpected error: %v", err)
+	}
+	if ones, _ := prefix.Mask.Size(); verb != "add" || ip.String() != "192.168.1.10" || ones != 24 {
+		t.Errorf("parseAddressChange = %s %s/%d, want add 192.168.1.10/24", verb, ip, ones)
+	}
+
+	for _, args := range [][]string{
+		{"add"},
+		{"remove", "10.0.0.1/8"},
+		{"del", "10.0.0.1"},
+		{"del", "2001:db8::1/129"},
+	} {
+		if _, _, _, err := parseAddressChange(args); err == nil {
+			t.Errorf("expected an error for %q", args)
+		}
+	}
+}
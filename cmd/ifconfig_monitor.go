@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/net"
+	"golang.org/x/term"
+)
+
+// interfaceCounters holds a sample of an interface's byte and packet counters
+type interfaceCounters struct {
+	bytesRecv, bytesSent     uint64
+	packetsRecv, packetsSent uint64
+}
+
+// readInterfaceCounters samples the counters of every interface, keyed by name
+func readInterfaceCounters() (map[string]interfaceCounters, error) {
+	stats, err := psnet.IOCounters(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interface counters: %v", err)
+	}
+	counters := make(map[string]interfaceCounters, len(stats))
+	for _, s := range stats {
+		counters[s.Name] = interfaceCounters{
+			bytesRecv:   s.BytesRecv,
+			bytesSent:   s.BytesSent,
+			packetsRecv: s.PacketsRecv,
+			packetsSent: s.PacketsSent,
+		}
+	}
+	return counters, nil
+}
+
+// monitorInterfaces samples the interface counters every interval and prints the
+// receive and transmit rates until interrupted. With no name, all interfaces are shown.
+func monitorInterfaces(name string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	previous, err := readInterfaceCounters()
+	if err != nil {
+		return err
+	}
+	if _, ok := previous[name]; name != "" && !ok {
+		return fmt.Errorf("no counters for interface %s", name)
+	}
+
+	// Redraw in place on a terminal; otherwise append one block per sample
+	refresh := term.IsTerminal(int(os.Stdout.Fd()))
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+
+	for {
+		select {
+		case <-sigCh:
+			fmt.Println()
+			return nil
+		case now := <-ticker.C:
+			current, err := readInterfaceCounters()
+			if err != nil {
+				return err
+			}
+			elapsed := now.Sub(last).Seconds()
+			last = now
+
+			if refresh {
+				fmt.Print("\033[H\033[2J")
+			}
+			fmt.Printf("%-16s %14s %14s %12s %12s   (every %s, Ctrl-C to quit)\n",
+				"Interface", "RX", "TX", "RX pkt/s", "TX pkt/s", interval)
+
+			names := make([]string, 0, len(current))
+			for n := range current {
+				if name == "" || n == name {
+					names = append(names, n)
+				}
+			}
+			sort.Strings(names)
+
+			for _, n := range names {
+				cur, prev := current[n], previous[n]
+				fmt.Printf("%-16s %14s %14s %12.1f %12.1f\n", n,
+					formatRate(counterDelta(cur.bytesRecv, prev.bytesRecv), elapsed),
+					formatRate(counterDelta(cur.bytesSent, prev.bytesSent), elapsed),
+					float64(counterDelta(cur.packetsRecv, prev.packetsRecv))/elapsed,
+					float64(counterDelta(cur.packetsSent, prev.packetsSent))/elapsed)
+			}
+			if !refresh {
+				fmt.Println()
+			}
+			previous = current
+		}
+	}
+}
+
+// counterDelta returns how much a counter grew, treating a reset or wrap as zero
+func counterDelta(current, previous uint64) uint64 {
+	if current < previous {
+		return 0
+	}
+	return current - previous
+}
+
+// formatRate renders a byte count over the elapsed seconds as a human-readable rate
+func formatRate(bytes uint64, seconds float64) string {
+	rate := float64(bytes) / seconds
+	units := []string{"B/s", "KB/s", "MB/s", "GB/s"}
+	unit := 0
+	for rate >= 1024 && unit < len(units)-1 {
+		rate /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", rate, units[unit])
+}
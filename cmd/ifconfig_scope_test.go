@@ -0,0 +1,25 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPv6Scope(t *testing.T) {
+	tests := map[string]string{
+		"fe80::1":      "link-local",
+		"fd12:3456::1": "unique-local",
+		"2001:db8::1":  "global",
+		"::1":          "loopback",
+		"ff02::1":      "multicast",
+		"::":           "unspecified",
+	}
+	for addr, want := range tests {
+		if got := ipv6Scope(net.ParseIP(addr)); got != want {
+			t.Errorf("ipv6Scope(%s) = %s, want %s", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// commandLog is the logger behind --log-file; nil when no log file was requested
+var commandLog *slog.Logger
+
+// commandStart is when the logged command started, for the duration of the finish record
+var commandStart time.Time
+
+// appendFile is an io.Writer that opens the file in append mode for every record and
+// closes it again, so log rotation (moving or truncating the file) never loses entries
+// and concurrent netro processes can share one log file
+type appendFile struct {
+	path string
+}
+
+func (f appendFile) Write(p []byte) (int, error) {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.Write(p)
+}
+
+// startCommandLog sets up the command log and records the invocation. The matching
+// finish record is written by PersistentPostRun, Execute or exitCommand.
+func startCommandLog(path string, cmd *cobra.Command) error {
+	// Fail early rather than run without the requested audit trail
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open --log-file: %v", err)
+	}
+	file.Close()
+
+	commandLog = slog.New(slog.NewJSONHandler(appendFile{path: path}, nil))
+	commandStart = time.Now()
+	commandLog.Info("command started",
+		"command", cmd.CommandPath(),
+		"args", redactArgs(os.Args[1:]),
+		"pid", os.Getpid(),
+	)
+	return nil
+}
+
+// secretFlags are the flags whose value is masked in the command log
+var secretFlags = map[string]bool{
+	"--oauth2-client-secret": true,
+}
+
+// secretHeaders are the (lower-cased) headers carrying credentials, masked in the
+// command log and in HAR files
+var secretHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+}
+
+// redactedValue replaces a masked value
+const redactedValue = "REDACTED"
+
+// redactArgs masks the secrets in a command line before it's logged: the values of
+// secret flags, credential headers given with -H and passwords in URLs
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, inline := strings.Cut(arg, "=")
+		switch {
+		case !strings.HasPrefix(arg, "--") && strings.HasPrefix(arg, "-H") && len(arg) > 2:
+			redacted[i] = "-H" + redactHeader(arg[2:])
+		case (arg == "-H" || arg == "--header") && i+1 < len(args):
+			redacted[i] = arg
+			i++
+			redacted[i] = redactHeader(args[i])
+		case name == "--header" && inline:
+			redacted[i] = name + "=" + redactHeader(value)
+		case secretFlags[name] && inline:
+			redacted[i] = name + "=" + redactedValue
+		case secretFlags[arg] && i+1 < len(args):
+			redacted[i] = arg
+			i++
+			redacted[i] = redactedValue
+		default:
+			redacted[i] = redactURL(arg)
+		}
+	}
+	return redacted
+}
+
+// redactHeader masks the value of a "Name: value" header that carries credentials
+func redactHeader(header string) string {
+	name, _, found := strings.Cut(header, ":")
+	if found && secretHeaders[strings.ToLower(strings.TrimSpace(name))] {
+		return name + ": " + redactedValue
+	}
+	return header
+}
+
+// redactURL masks the password of a URL (with or without a flag name before it)
+func redactURL(arg string) string {
+	prefix, rawURL := "", arg
+	if name, value, found := strings.Cut(arg, "="); found && strings.HasPrefix(name, "-") {
+		prefix, rawURL = name+"=", value
+	}
+	if !strings.Contains(rawURL, "://") || !strings.Contains(rawURL, "@") {
+		return arg
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return arg
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+	return prefix + u.String()
+}
+
+// finishCommandLog records how long the command took and how it ended
+func finishCommandLog(code int, err error) {
+	if commandLog == nil {
+		return
+	}
+	attrs := []any{
+		"duration", time.Since(commandStart).String(),
+		"exit_code", code,
+		"pid", os.Getpid(),
+	}
+	if err != nil {
+		commandLog.Error("command failed", append(attrs, "error", err.Error())...)
+		return
+	}
+	commandLog.Info("command finished", attrs...)
+}
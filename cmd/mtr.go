@@ -0,0 +1,334 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/term"
+)
+
+// mtrCmd represents the mtr command
+var mtrCmd = &cobra.Command{
+	Use:   "mtr [host]",
+	Short: "Continuously trace the path to a host, with loss and latency per hop",
+	Long: `Netro's mtr command combines traceroute and ping: every interval it sends an ICMP echo
+request to each hop on the path to the host (by increasing the TTL) and keeps per-hop loss
+and round-trip statistics, redrawing the table as results come in.
+Use --count to stop after a number of cycles and --report to print only the final table.
+Sending and receiving the probes needs raw sockets, so mtr must run as root (or with CAP_NET_RAW).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		count, _ := cmd.Flags().GetInt("count")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		maxHops, _ := cmd.Flags().GetInt("max-hops")
+		report, _ := cmd.Flags().GetBool("report")
+
+		// A report needs an end; mtr uses ten cycles by default
+		if report && !cmd.Flags().Changed("count") {
+			count = 10
+		}
+
+		opts := mtrOptions{
+			count:    count,
+			interval: interval,
+			maxHops:  maxHops,
+			report:   report,
+		}
+
+		if err := executeMTR(args[0], opts); err != nil {
+			fatalf("Error executing mtr: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mtrCmd)
+
+	// Define flags for the mtr command
+	mtrCmd.Flags().IntP("count", "c", 0, "Number of cycles to run (0 runs until Ctrl-C; 10 with --report)")
+	mtrCmd.Flags().DurationP("interval", "i", time.Second, "Time between cycles; replies arriving later count as lost")
+	mtrCmd.Flags().Int("max-hops", 30, "Maximum number of hops (TTL) to probe")
+	mtrCmd.Flags().BoolP("report", "r", false, "Print only the final table instead of a live display")
+}
+
+// mtrOptions holds the settings collected from the mtr command's flags
+type mtrOptions struct {
+	count    int
+	interval time.Duration
+	maxHops  int
+	report   bool
+}
+
+// mtrHop accumulates the probe results of a single hop
+type mtrHop struct {
+	addr  string
+	sent  int
+	recv  int
+	last  time.Duration
+	best  time.Duration
+	worst time.Duration
+	total time.Duration
+}
+
+// record adds a reply with the given round-trip time
+func (h *mtrHop) record(addr string, rtt time.Duration) {
+	h.addr = addr
+	h.recv++
+	h.last = rtt
+	h.total += rtt
+	if h.best == 0 || rtt < h.best {
+		h.best = rtt
+	}
+	if rtt > h.worst {
+		h.worst = rtt
+	}
+}
+
+// mtrProbe is an echo request waiting for its reply
+type mtrProbe struct {
+	ttl    int
+	sentAt time.Time
+}
+
+// mtrSession holds the socket and state of a path trace
+type mtrSession struct {
+	conn   *icmp.PacketConn
+	dest   *net.IPAddr
+	ipv4   bool
+	id     int
+	seq    int
+	hops   []mtrHop // indexed by TTL-1
+	last   int      // TTL at which the destination answers, or 0 while unknown
+	cycles int
+}
+
+// executeMTR probes the path to host every interval and prints per-hop statistics
+func executeMTR(host string, opts mtrOptions) error {
+	if opts.interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	if opts.maxHops < 1 || opts.maxHops > 255 {
+		return fmt.Errorf("--max-hops must be between 1 and 255, got %d", opts.maxHops)
+	}
+
+	dest, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+
+	session := &mtrSession{
+		dest: dest,
+		ipv4: dest.IP.To4() != nil,
+		id:   os.Getpid() & 0xffff,
+		hops: make([]mtrHop, opts.maxHops),
+	}
+	if session.ipv4 {
+		session.conn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	} else {
+		session.conn, err = icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open ICMP socket (mtr needs root or CAP_NET_RAW): %v", err)
+	}
+	defer session.conn.Close()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	// Redraw in place on a terminal; otherwise only print the final table
+	live := !opts.report && term.IsTerminal(int(os.Stdout.Fd()))
+
+	for opts.count == 0 || session.cycles < opts.count {
+		if err := session.cycle(opts.interval); err != nil {
+			return err
+		}
+		if live {
+			fmt.Print("\033[H\033[2J")
+			session.print(host)
+		}
+
+		select {
+		case <-sigCh:
+			if !live {
+				session.print(host)
+			}
+			return nil
+		default:
+		}
+	}
+
+	if !live {
+		session.print(host)
+	}
+	return nil
+}
+
+// cycle sends one probe per hop and collects the replies that arrive within the interval
+func (s *mtrSession) cycle(interval time.Duration) error {
+	deadline := time.Now().Add(interval)
+	pending := make(map[int]mtrProbe)
+
+	maxTTL := len(s.hops)
+	if s.last > 0 {
+		maxTTL = s.last
+	}
+	for ttl := 1; ttl <= maxTTL; ttl++ {
+		s.seq = (s.seq + 1) & 0xffff
+		if err := s.send(ttl, s.seq); err != nil {
+			return err
+		}
+		pending[s.seq] = mtrProbe{ttl: ttl, sentAt: time.Now()}
+		s.hops[ttl-1].sent++
+	}
+
+	buf := make([]byte, 1500)
+	for len(pending) > 0 {
+		s.conn.SetReadDeadline(deadline)
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return fmt.Errorf("failed to read reply: %v", err)
+		}
+		received := time.Now()
+
+		seq, reached, ok := s.parseReply(buf[:n])
+		if !ok {
+			continue
+		}
+		probe, ok := pending[seq]
+		if !ok {
+			continue
+		}
+		delete(pending, seq)
+
+		addr := peer.String()
+		if ipAddr, ok := peer.(*net.IPAddr); ok {
+			addr = ipAddr.IP.String()
+		}
+		s.hops[probe.ttl-1].record(addr, received.Sub(probe.sentAt))
+		if reached && (s.last == 0 || probe.ttl < s.last) {
+			s.last = probe.ttl
+		}
+	}
+
+	// Wait out the rest of the interval so cycles are evenly spaced
+	time.Sleep(time.Until(deadline))
+	s.cycles++
+	return nil
+}
+
+// send transmits an echo request with the given TTL and sequence number
+func (s *mtrSession) send(ttl, seq int) error {
+	msg := icmp.Message{
+		Body: &icmp.Echo{ID: s.id, Seq: seq, Data: []byte("netro-mtr")},
+	}
+	if s.ipv4 {
+		msg.Type = ipv4.ICMPTypeEcho
+		if err := s.conn.IPv4PacketConn().SetTTL(ttl); err != nil {
+			return fmt.Errorf("failed to set TTL: %v", err)
+		}
+	} else {
+		msg.Type = ipv6.ICMPTypeEchoRequest
+		if err := s.conn.IPv6PacketConn().SetHopLimit(ttl); err != nil {
+			return fmt.Errorf("failed to set hop limit: %v", err)
+		}
+	}
+
+	packet, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.WriteTo(packet, s.dest); err != nil {
+		return fmt.Errorf("failed to send probe: %v", err)
+	}
+	return nil
+}
+
+// parseReply returns the sequence number of the probe an ICMP message answers and whether
+// it came from the destination. Errors from routers quote the header of the original probe.
+func (s *mtrSession) parseReply(b []byte) (seq int, reached, ok bool) {
+	proto, headerLen := 1, 0 // ICMPv4; quoted IPv4 headers have a variable length
+	if !s.ipv4 {
+		proto, headerLen = 58, 40 // ICMPv6; the IPv6 header is fixed
+	}
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return 0, false, false
+	}
+
+	var quoted []byte
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply {
+			return 0, false, false
+		}
+		if body.ID != s.id {
+			return 0, false, false
+		}
+		return body.Seq, true, true
+	case *icmp.TimeExceeded:
+		quoted = body.Data
+	case *icmp.DstUnreach:
+		quoted = body.Data
+		reached = true // the path ends here
+	default:
+		return 0, false, false
+	}
+
+	if s.ipv4 {
+		if len(quoted) < 1 {
+			return 0, false, false
+		}
+		headerLen = int(quoted[0]&0x0f) * 4
+	}
+	// The quoted echo request: type, code, checksum, identifier, sequence number
+	if len(quoted) < headerLen+8 {
+		return 0, false, false
+	}
+	echo := quoted[headerLen:]
+	if int(binary.BigEndian.Uint16(echo[4:6])) != s.id {
+		return 0, false, false
+	}
+	return int(binary.BigEndian.Uint16(echo[6:8])), reached, true
+}
+
+// print writes the per-hop statistics table
+func (s *mtrSession) print(host string) {
+	fmt.Printf("HOST: %s (%s)   cycles: %d\n", host, s.dest.IP, s.cycles)
+	fmt.Printf("%-4s %-40s %6s %5s %8s %8s %8s %8s\n", "", "Host", "Loss%", "Snt", "Last", "Avg", "Best", "Wrst")
+
+	hops := len(s.hops)
+	if s.last > 0 {
+		hops = s.last
+	}
+	for i := 0; i < hops; i++ {
+		hop := s.hops[i]
+		if hop.recv == 0 {
+			fmt.Printf("%3d. %-40s %5.1f%% %5d\n", i+1, "???", 100.0, hop.sent)
+			continue
+		}
+		loss := 100 * float64(hop.sent-hop.recv) / float64(hop.sent)
+		fmt.Printf("%3d. %-40s %5.1f%% %5d %8.1f %8.1f %8.1f %8.1f\n", i+1, hop.addr, loss, hop.sent,
+			msDuration(hop.last), msDuration(hop.total/time.Duration(hop.recv)), msDuration(hop.best), msDuration(hop.worst))
+	}
+}
+
+// msDuration converts a duration to fractional milliseconds
+func msDuration(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
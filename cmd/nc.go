@@ -4,14 +4,15 @@ Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
 package cmd
 
 import (
-	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
-	"net/url"
 	"os"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -22,39 +23,343 @@ var ncCmd = &cobra.Command{
 	Use:   "nc [host] [port]",
 	Short: "Netro's implementation of Netcat (nc) for TCP and UDP connections",
 	Long: `Netro's Netcat (nc) command supports TCP and UDP connections for interacting 
-with remote servers. It can also listen for incoming connections using the -l flag.`,
-	Args: cobra.RangeArgs(1, 2), // Accept one or two arguments (host is optional in listen mode)
-	Run: func(cmd *cobra.Command, args []string) {
+with remote servers. It can also listen for incoming connections using the -l flag.
+Repeat --proxy to tunnel through a chain of HTTP and SOCKS5 proxies, in the order given. With socks5://
+nc resolves the names it asks the proxy to connect to; with socks5h:// the proxy resolves them.
+Listening on port 0 (or with --random-port) binds a free port chosen by the system and prints
+its number on a line of its own before accepting connections.
+Use --keepalive to send TCP keepalive probes on idle connections so NAT devices and firewalls
+don't drop them; the probes are handled by the kernel and never appear in the data stream.
+Small writes are sent immediately (--nodelay, the default); --nodelay=false turns Nagle's algorithm
+back on to coalesce them, which can suit bulk transfers. With -v the connection's MSS is printed on Linux.
+With --wait-for, nc only retries connecting every --retry-interval until the port is open (exit 0)
+or --max-wait passes (exit 1), e.g. to wait for a service in a container start-up script.
+With -z, nc scans a list of ports instead (e.g. "netro nc -z host 22,80,8000-8010"). A UDP scan (-p udp)
+marks a port open when it replies, closed on an ICMP port unreachable and open|filtered when it stays silent
+for --timeout, since UDP services and firewalls both drop datagrams without a word.
+--targets-from reads "host port" pairs, one per line, from a file or from stdin with "-", connects to
+them several at a time and prints each as open, closed, timeout or error in the order listed; the exit
+code is 0 only when every target is open. Blank lines and lines starting with # are skipped.
+--max-bytes closes the connection once that many bytes have been received (e.g. 100m), so a capture
+teed to a file can't fill the disk; the number of bytes received is reported at the end.
+--timestamp prefixes every line received with the time it arrived (e.g. 2024-05-01T12:00:00.123+02:00),
+to log a text protocol exchange; the tee file still gets the data as received.
+A failed outgoing connection sets the exit code like curl does, for scripts to branch on:
+6 when the host name can't be resolved, 7 when the connection is refused or the host or network
+is unreachable and 28 when the attempt times out (--timeout); other errors exit with 1.
+--init-send writes a payload such as 'EHLO localhost\r\n' as soon as the connection is up, then
+carries on with stdin as usual, to skip typing the same handshake in every session.
+--checksum sha256 prints the digest of each direction of a TCP session once it completes (end of
+input for the data sent, end of connection for the data received). A direction cut short by
+Ctrl-C or a connection error prints no checksum, since the data is incomplete; UDP has no end of transfer and is not supported.
+With --tls, nc speaks TLS to the server. --tls-servername sets the name sent as SNI and checked against
+the certificate, so "netro nc --tls --tls-servername api.example.com 10.0.0.5 443" tests one backend.
+With --listen, --tls terminates TLS with the --tls-cert and --tls-key PEM files and prints the version,
+cipher suite and server name negotiated with each client, for a quick TLS endpoint to test clients against.
+-4 and -6 restrict connections, listeners and scans to IPv4 or IPv6, e.g. to test a dual-stack name over
+each family in turn. An IPv6 link-local address needs the interface it is on as a zone, e.g.
+"netro nc fe80::1%eth0 22", or with --bind "fe80::1%eth0" to listen on it.`,
+	Args: cobra.RangeArgs(0, 2), // Host is optional in listen mode, and so is the port with --random-port
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var host, port string
 
 		// In listen mode, we only need the port; otherwise, both host and port
 		if len(args) == 1 {
 			port = args[0]
-		} else {
+		} else if len(args) == 2 {
 			host = args[0]
 			port = args[1]
 		}
 
+		// --targets-from reads the hosts and ports to check instead of taking them as arguments
+		targetsFrom, _ := cmd.Flags().GetString("targets-from")
+		if targetsFrom != "" && len(args) > 0 {
+			fatalf("Error executing nc: --targets-from does not take a host or port argument")
+		}
+
+		randomPort, _ := cmd.Flags().GetBool("random-port")
+		if randomPort {
+			if listen, _ := cmd.Flags().GetBool("listen"); !listen {
+				fatalf("Error executing nc: --random-port requires --listen")
+			}
+			if port != "" {
+				fatalf("Error executing nc: --random-port does not take a port argument")
+			}
+			port = "0"
+		}
+		if port == "" && targetsFrom == "" {
+			fatalf("Error executing nc: no port given")
+		}
+
 		// Fetch flags
 		protocol, _ := cmd.Flags().GetString("protocol")
 		timeout, _ := cmd.Flags().GetDuration("timeout")
-		proxy, _ := cmd.Flags().GetString("proxy")
+		proxies, _ := cmd.Flags().GetStringArray("proxy")
 		listen, _ := cmd.Flags().GetBool("listen")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		recvOnly, _ := cmd.Flags().GetBool("recv-only")
+		sendOnly, _ := cmd.Flags().GetBool("send-only")
+		telnet, _ := cmd.Flags().GetBool("telnet")
+		banner, _ := cmd.Flags().GetBool("banner")
+		probe, _ := cmd.Flags().GetString("probe")
+		bind, _ := cmd.Flags().GetString("bind")
+		sendDelay, _ := cmd.Flags().GetDuration("send-delay")
+		teeFile, _ := cmd.Flags().GetString("tee")
+		teeBoth, _ := cmd.Flags().GetBool("tee-both")
+		bufferSize, _ := cmd.Flags().GetString("buffer-size")
+		hexdump, _ := cmd.Flags().GetBool("hexdump")
+		keepAlive, _ := cmd.Flags().GetDuration("keepalive")
+		noDelay, _ := cmd.Flags().GetBool("nodelay")
+		waitFor, _ := cmd.Flags().GetBool("wait-for")
+		scan, _ := cmd.Flags().GetBool("scan")
+		retryInterval, _ := cmd.Flags().GetDuration("retry-interval")
+		maxWait, _ := cmd.Flags().GetDuration("max-wait")
+		useTLS, _ := cmd.Flags().GetBool("tls")
+		tlsServerName, _ := cmd.Flags().GetString("tls-servername")
+		tlsInsecure, _ := cmd.Flags().GetBool("tls-insecure")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		tlsKey, _ := cmd.Flags().GetString("tls-key")
+		checksum, _ := cmd.Flags().GetString("checksum")
+		limitRate, _ := cmd.Flags().GetString("limit-rate")
+		limitRateRecv, _ := cmd.Flags().GetString("limit-rate-recv")
+		initSend, _ := cmd.Flags().GetString("init-send")
+		maxBytesFlag, _ := cmd.Flags().GetString("max-bytes")
+		timestamp, _ := cmd.Flags().GetBool("timestamp")
+		ipv4Only, _ := cmd.Flags().GetBool("ipv4")
+		ipv6Only, _ := cmd.Flags().GetBool("ipv6")
+		family := ""
+		if ipv4Only {
+			family = "4"
+		} else if ipv6Only {
+			family = "6"
+		}
+
+		size, err := parseByteSize(bufferSize)
+		if err != nil || size < 1 || size > 1<<30 {
+			fatalf("Error executing nc: invalid --buffer-size %q", bufferSize)
+		}
+
+		// Zero (the default) leaves a direction unthrottled
+		sendRate, err := parseByteSize(limitRate)
+		if err != nil {
+			fatalf("Error executing nc: invalid --limit-rate: %v", err)
+		}
+		recvRate, err := parseByteSize(limitRateRecv)
+		if err != nil {
+			fatalf("Error executing nc: invalid --limit-rate-recv: %v", err)
+		}
+
+		maxBytes, err := parseByteSize(maxBytesFlag)
+		if err != nil {
+			fatalf("Error executing nc: invalid --max-bytes: %v", err)
+		}
+
+		if _, ok := checksumAlgorithms[checksum]; checksum != "" && !ok {
+			fatalf("Error executing nc: unsupported --checksum %q (use md5, sha1 or sha256)", checksum)
+		}
+		if checksum != "" && protocol != "tcp" {
+			fatalf("Error executing nc: --checksum works with TCP only, as UDP has no end of transfer")
+		}
+
+		// Only listen on an address this host actually has
+		if bind != "" {
+			if !listen {
+				fatalf("Error executing nc: --bind requires --listen")
+			}
+			var err error
+			if family != "" && net.ParseIP(bind) == nil && !strings.Contains(bind, "%") {
+				// An interface name binds to its primary address in the chosen family
+				bind, err = interfaceSourceAddress(bind, family == "4")
+			} else {
+				bind, err = resolveBindAddress(bind)
+			}
+			if err == nil {
+				err = checkHostFamily(bind, family)
+			}
+			if err != nil {
+				fatalf("Error executing nc: %v", err)
+			}
+		}
+
+		if _, ok := ncProbes[probe]; probe != "" && !ok {
+			fatalf("Error executing nc: unknown --probe %q (use %s)", probe, strings.Join(ncProbeNames(), ", "))
+		}
+		if probe != "" && (listen || protocol != "tcp") {
+			fatalf("Error executing nc: --probe works with outgoing TCP connections only")
+		}
+
+		initPayload, err := decodeEscapes(initSend)
+		if err != nil {
+			fatalf("Error executing nc: invalid --init-send: %v", err)
+		}
+
+		if keepAlive < 0 {
+			fatalf("Error executing nc: --keepalive must not be negative")
+		}
+
+		if sendDelay < 0 {
+			fatalf("Error executing nc: --send-delay must not be negative")
+		}
+
+		if telnet && !stdinIsTerminal() {
+			fatalf("Error executing nc: --telnet requires stdin to be a terminal")
+		}
+
+		opts := ncOptions{
+			protocol:      protocol,
+			timeout:       timeout,
+			proxies:       proxies,
+			verbose:       verbose,
+			recvOnly:      recvOnly,
+			sendOnly:      sendOnly,
+			telnet:        telnet,
+			banner:        banner,
+			probe:         probe,
+			bind:          bind,
+			sendDelay:     sendDelay,
+			bufferSize:    int(size),
+			hexdump:       hexdump,
+			keepAlive:     keepAlive,
+			noDelay:       noDelay,
+			tls:           useTLS,
+			tlsServerName: tlsServerName,
+			tlsInsecure:   tlsInsecure,
+			tlsCert:       tlsCert,
+			tlsKey:        tlsKey,
+			checksum:      checksum,
+			sendRate:      sendRate,
+			recvRate:      recvRate,
+			initSend:      initPayload,
+			maxBytes:      maxBytes,
+			timestamp:     timestamp,
+			family:        family,
+			session:       newNCSession(),
+		}
+
+		// Capture the session to a file alongside the normal output
+		if teeBoth && teeFile == "" {
+			fatalf("Error executing nc: --tee-both requires --tee")
+		}
+		if teeFile != "" {
+			file, err := os.Create(teeFile)
+			if err != nil {
+				fatalf("Error executing nc: failed to create tee file: %v", err)
+			}
+			defer file.Close()
+			opts.tee = &lockedWriter{w: file}
+			opts.teeBoth = teeBoth
+		}
+
+		if (tlsServerName != "" || tlsInsecure) && !useTLS {
+			fatalf("Error executing nc: --tls-servername and --tls-insecure require --tls")
+		}
+		if useTLS && protocol != "tcp" {
+			fatalf("Error executing nc: --tls works with TCP only")
+		}
+		// A TLS listener presents the given certificate; a client verifies the server's
+		if (tlsCert != "" || tlsKey != "") && !(useTLS && listen) {
+			fatalf("Error executing nc: --tls-cert and --tls-key require --tls and --listen")
+		}
+		if useTLS && listen {
+			if tlsCert == "" || tlsKey == "" {
+				fatalf("Error executing nc: --tls with --listen requires --tls-cert and --tls-key")
+			}
+			if tlsServerName != "" || tlsInsecure {
+				fatalf("Error executing nc: --tls-servername and --tls-insecure apply to outgoing connections only")
+			}
+		}
+
+		// A literal address must match -4/-6, and a link-local one needs its interface
+		if host != "" {
+			if err := checkHostFamily(host, family); err != nil {
+				fatalf("Error executing nc: %v", err)
+			}
+		}
+
+		// Only wait for the port to accept connections, without exchanging data
+		if waitFor {
+			if listen || protocol != "tcp" || host == "" {
+				fatalf("Error executing nc: --wait-for needs a host and port and works with TCP only")
+			}
+			if retryInterval <= 0 || maxWait <= 0 {
+				fatalf("Error executing nc: --retry-interval and --max-wait must be positive")
+			}
+			if err := waitForPort(net.JoinHostPort(host, port), retryInterval, maxWait, opts); err != nil {
+				fatalf("Error executing nc: %v", err)
+			}
+			return nil
+		}
+
+		// Only report which ports are open, without exchanging data
+		if scan {
+			if listen || host == "" || len(proxies) > 0 || useTLS || probe != "" {
+				fatalf("Error executing nc: -z needs a host and ports and works without --listen, --proxy, --tls or --probe")
+			}
+			if protocol == "udp" && timeout <= 0 {
+				fatalf("Error executing nc: a UDP scan needs a --timeout above 0 to wait for replies")
+			}
+			ports, err := parsePortList(port)
+			if err != nil {
+				fatalf("Error executing nc: %v", err)
+			}
+			if err := scanPorts(host, ports, opts); err != nil {
+				fatalf("Error executing nc: %v", err)
+			}
+			return nil
+		}
+
+		// Only report which of the listed targets accept a connection
+		if targetsFrom != "" {
+			if listen || protocol != "tcp" || len(proxies) > 0 || useTLS || probe != "" {
+				fatalf("Error executing nc: --targets-from works with TCP only and without --listen, --proxy, --tls or --probe")
+			}
+			source, err := openTargets(targetsFrom)
+			if err != nil {
+				fatalf("Error executing nc: %v", err)
+			}
+			targets, err := parseTargets(source)
+			source.Close()
+			if err != nil {
+				fatalf("Error executing nc: invalid --targets-from: %v", err)
+			}
+			if len(targets) == 0 {
+				fatalf("Error executing nc: --targets-from lists no targets")
+			}
+			if err := checkTargets(targets, opts); err != nil {
+				fatalf("Error executing nc: %v", err)
+			}
+			return nil
+		}
+
+		// Close the session cleanly and print transfer statistics on Ctrl-C
+		opts.session.handleInterrupt()
 
 		// Execute the appropriate logic (listen mode or normal mode)
 		if listen {
-			err := executeNCListen(port, protocol)
+			err := executeNCListen(port, opts)
+			if opts.session.wasInterrupted() {
+				return nil
+			}
 			if err != nil {
-				fmt.Printf("Error executing nc listen: %v\n", err)
-				os.Exit(1)
+				fatalf("Error executing nc listen: %v", err)
 			}
 		} else {
-			err := executeNC(host, port, protocol, timeout, proxy)
+			err := executeNC(host, port, opts)
+			if opts.session.wasInterrupted() {
+				return nil
+			}
 			if err != nil {
 				fmt.Printf("Error executing nc: %v\n", err)
-				os.Exit(1)
+				// Failures to connect have their own exit codes, which Execute applies
+				if code := connectExitCode(err); code != 1 {
+					cmd.SilenceErrors = true
+					cmd.SilenceUsage = true
+					return &exitError{code: code, err: err}
+				}
+				exitCommand(1, err)
 			}
 		}
+		return nil
 	},
 }
 
@@ -64,42 +369,190 @@ func init() {
 	// Define flags for the nc command
 	ncCmd.Flags().StringP("protocol", "p", "tcp", "Specify the protocol to use (tcp or udp)")
 	ncCmd.Flags().DurationP("timeout", "t", 5*time.Second, "Set timeout duration for the connection")
-	ncCmd.Flags().StringP("proxy", "x", "", "Specify a TCP proxy URL for TCP connections (e.g., http://proxy.example.com:8080)")
+	ncCmd.Flags().StringArrayP("proxy", "x", nil, "Proxy URL for TCP connections (http://, socks5:// or socks5h:// for proxy-side DNS); repeat to chain proxies in order")
 	ncCmd.Flags().BoolP("listen", "l", false, "Listen for incoming connections on the specified port")
+	ncCmd.Flags().String("bind", "", "In listen mode, listen only on this local address or on the address of this interface (e.g. 127.0.0.1 or lo)")
+	ncCmd.Flags().Bool("random-port", false, "In listen mode, bind a free port chosen by the system and print it")
+	ncCmd.Flags().BoolP("verbose", "v", false, "Print resolved addresses and connection events to stderr")
+	ncCmd.Flags().Bool("recv-only", false, "Only receive data; never read stdin and exit when the remote side closes")
+	ncCmd.Flags().Bool("send-only", false, "Only send stdin; exit at end of input without reading from the connection")
+	ncCmd.Flags().Bool("telnet", false, "Interactive mode with local echo and line editing; Ctrl-C/Ctrl-D go to the remote, Ctrl-] quits")
+	ncCmd.Flags().Bool("banner", false, "Print the greeting the server sends within the timeout, then disconnect without sending anything")
+	ncCmd.Flags().Duration("send-delay", 0, "Send stdin line by line, pausing this long between lines (e.g. 200ms)")
+	ncCmd.Flags().String("buffer-size", "32k", "Size of the read/write buffers for TCP copies and UDP datagrams, with optional k/m suffix")
+	ncCmd.Flags().Bool("hexdump", false, "Print received data as a hex+ASCII dump; the UDP listener dumps each datagram with its source")
+	ncCmd.MarkFlagsMutuallyExclusive("hexdump", "telnet")
+	ncCmd.Flags().Bool("timestamp", false, "Prefix each line received with its ISO-8601 arrival time, for correlating with other logs")
+	ncCmd.MarkFlagsMutuallyExclusive("timestamp", "hexdump")
+	ncCmd.Flags().Duration("keepalive", 0, "Send TCP keepalive probes after this much idle time (e.g. 30s); 0 keeps the system default")
+	ncCmd.Flags().Bool("nodelay", true, "Disable Nagle's algorithm so small writes go out at once; --nodelay=false coalesces them")
+	ncCmd.Flags().Bool("wait-for", false, "Retry connecting until the port is open, then exit 0; exit 1 after --max-wait")
+	ncCmd.Flags().BoolP("scan", "z", false, "Scan the ports (e.g. 22,80,8000-8010) and report each as open, closed or filtered; UDP ports that never reply are open|filtered")
+	ncCmd.MarkFlagsMutuallyExclusive("scan", "wait-for")
+	ncCmd.Flags().String("targets-from", "", "Check the \"host port\" pairs listed one per line in this file, or stdin for -, and report each as open, closed, timeout or error")
+	ncCmd.MarkFlagsMutuallyExclusive("targets-from", "scan", "wait-for", "listen")
+	ncCmd.Flags().Duration("retry-interval", time.Second, "Pause between connection attempts with --wait-for")
+	ncCmd.Flags().Duration("max-wait", time.Minute, "Give up waiting after this long with --wait-for")
+	ncCmd.Flags().Bool("tls", false, "Connect with TLS, verifying the server certificate; with --listen, accept TLS connections using --tls-cert and --tls-key")
+	ncCmd.Flags().String("tls-servername", "", "With --tls, the server name sent as SNI and verified against the certificate (default: the host argument)")
+	ncCmd.Flags().Bool("tls-insecure", false, "With --tls, skip verification of the server certificate")
+	ncCmd.Flags().BoolP("ipv4", "4", false, "Use IPv4 only, for connecting and listening")
+	ncCmd.Flags().BoolP("ipv6", "6", false, "Use IPv6 only, for connecting and listening")
+	ncCmd.MarkFlagsMutuallyExclusive("ipv4", "ipv6")
+	ncCmd.Flags().String("tls-cert", "", "With --tls and --listen, the PEM certificate (and chain) presented to clients")
+	ncCmd.Flags().String("tls-key", "", "With --tls and --listen, the PEM private key of --tls-cert")
+	ncCmd.Flags().String("tee", "", "Also write the data received from the connection to this file")
+	ncCmd.Flags().Bool("tee-both", false, "With --tee, also write the data sent, interleaved with the data received")
+	ncCmd.Flags().String("limit-rate", "0", "Limit the rate data is sent at, in bytes per second with optional k/m/g suffix (e.g. 50k); 0 for unlimited")
+	ncCmd.Flags().String("limit-rate-recv", "0", "Limit the rate data is received at, like --limit-rate")
+	ncCmd.Flags().String("max-bytes", "0", "Close the connection after receiving this many bytes, with optional k/m/g suffix (e.g. 1m); 0 for no limit")
+	ncCmd.MarkFlagsMutuallyExclusive("max-bytes", "send-only")
+	ncCmd.Flags().String("checksum", "", "Print a checksum (md5, sha1 or sha256) of the data sent and received once each direction of a TCP session completes")
+	ncCmd.Flags().String("probe", "", "Send a minimal protocol request (http, smtp or redis), print the reply and exit non-zero if it is missing or unexpected")
+	ncCmd.Flags().String("init-send", "", "Send this payload right after connecting, then continue with stdin; decodes \\r, \\n, \\t, \\0, \\\\ and \\xNN (e.g. 'EHLO localhost\\r\\n')")
+	ncCmd.MarkFlagsMutuallyExclusive("recv-only", "send-only", "telnet", "banner", "probe")
+	ncCmd.MarkFlagsMutuallyExclusive("init-send", "banner")
+	ncCmd.MarkFlagsMutuallyExclusive("init-send", "probe")
+	ncCmd.MarkFlagsMutuallyExclusive("init-send", "scan")
+	ncCmd.MarkFlagsMutuallyExclusive("send-delay", "probe")
+	ncCmd.MarkFlagsMutuallyExclusive("send-delay", "recv-only")
+	ncCmd.MarkFlagsMutuallyExclusive("send-delay", "telnet")
+	ncCmd.MarkFlagsMutuallyExclusive("send-delay", "banner")
+}
+
+// ncOptions holds the settings collected from the nc command's flags
+type ncOptions struct {
+	protocol      string
+	timeout       time.Duration
+	proxies       []string // proxy URLs to tunnel through, in order
+	verbose       bool
+	recvOnly      bool
+	sendOnly      bool
+	telnet        bool
+	banner        bool
+	probe         string        // protocol whose greeting is sent by --probe; empty for none
+	bind          string        // local address the listener binds to; all addresses if empty
+	sendDelay     time.Duration // pause between stdin lines; 0 sends input as it arrives
+	tee           io.Writer     // receives a copy of the data read from the connection, if set
+	teeBoth       bool          // also copy the data sent to tee
+	bufferSize    int           // bytes per read/write on the data paths; also the largest UDP datagram received
+	hexdump       bool          // dump received bytes in hex instead of writing them as-is
+	keepAlive     time.Duration // TCP keepalive period; 0 leaves the default in place
+	noDelay       bool          // send small writes immediately instead of coalescing them (Nagle)
+	tls           bool          // wrap outgoing TCP connections in TLS
+	tlsServerName string        // SNI and verification name; the host argument if empty
+	tlsInsecure   bool          // skip certificate verification
+	tlsCert       string        // PEM certificate presented by a TLS listener
+	tlsKey        string        // PEM key of tlsCert
+	tlsServer     *tls.Config   // set by executeNCListen to terminate TLS on accepted connections
+	checksum      string        // hash algorithm for the transfer checksums; empty for none
+	sendRate      int64         // bytes per second sent, 0 for unlimited
+	recvRate      int64         // bytes per second received, 0 for unlimited
+	initSend      []byte        // sent as soon as the connection is up, before stdin
+	maxBytes      int64         // bytes received before the connection is closed, 0 for no limit
+	timestamp     bool          // prefix each received line with the time it arrived
+	family        string        // "4" or "6" to use only IPv4 or IPv6; either if empty
+	session       *ncSession
+}
+
+// network returns the network to dial or listen on for proto ("tcp" or "udp"),
+// restricted to the address family chosen with -4 or -6, e.g. "tcp6"
+func (o ncOptions) network(proto string) string {
+	return proto + o.family
+}
+
+// deadline returns when an exchange bounded by the timeout must be done, or the zero
+// time (no deadline) when --timeout is 0, which means no limit as it does for the dial
+func (o ncOptions) deadline() time.Time {
+	if o.timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(o.timeout)
+}
+
+// logf prints a diagnostic message to stderr when verbose mode is enabled,
+// so that stdout stays clean for the data stream
+func (o ncOptions) logf(format string, args ...interface{}) {
+	if !o.verbose {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "nc: "+format+"\n", args...)
 }
 
 // executeNC handles TCP or UDP connections based on the provided protocol
-func executeNC(host, port, protocol string, timeout time.Duration, proxy string) error {
+func executeNC(host, port string, opts ncOptions) error {
 	address := net.JoinHostPort(host, port)
 
-	if protocol == "tcp" {
+	if opts.protocol == "tcp" {
 		// Handle TCP connection
-		if proxy != "" {
-			// Use proxy for TCP connection
-			return executeTCPProxy(address, timeout, proxy)
+		if len(opts.proxies) > 0 {
+			// Use the proxy chain for TCP connection
+			return executeTCPProxy(address, opts)
 		}
-		return executeTCP(address, timeout)
-	} else if protocol == "udp" {
+		return executeTCP(address, opts)
+	} else if opts.protocol == "udp" {
 		// Handle UDP connection
-		return executeUDP(address, timeout)
+		return executeUDP(address, opts)
 	} else {
-		return fmt.Errorf("unsupported protocol: %s", protocol)
+		return fmt.Errorf("unsupported protocol: %s", opts.protocol)
+	}
+}
+
+// Exit codes for failed outgoing connections, the same as curl's
+const (
+	ncExitResolve = 6  // the host name could not be resolved
+	ncExitConnect = 7  // the connection was refused, or the host or network is unreachable
+	ncExitTimeout = 28 // the connection attempt timed out
+)
+
+// connectExitCode classifies an error from establishing a connection into the exit
+// code reported for it, 1 for anything that isn't a connection failure
+func connectExitCode(err error) int {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.Err != "unknown port":
+		// Go reports an unknown service name as a DNS error too
+		return ncExitResolve
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ncExitConnect
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ncExitTimeout
+	default:
+		return 1
 	}
 }
 
 // executeNCListen handles listening for incoming connections on the specified port
-func executeNCListen(port, protocol string) error {
-	address := net.JoinHostPort("", port) // Listen on all available interfaces
+func executeNCListen(port string, opts ncOptions) error {
+	address := net.JoinHostPort(opts.bind, port) // All available interfaces unless --bind is given
+
+	if opts.protocol == "tcp" {
+		// Terminate TLS on the accepted connections with the given key pair
+		transport := "TCP"
+		if opts.tls {
+			config, err := loadTLSServerConfig(opts.tlsCert, opts.tlsKey)
+			if err != nil {
+				return err
+			}
+			opts.tlsServer = config
+			transport = "TCP, TLS"
+		}
 
-	if protocol == "tcp" {
 		// Start TCP listener
-		listener, err := net.Listen("tcp", address)
+		listener, err := net.Listen(opts.network("tcp"), address)
 		if err != nil {
 			return fmt.Errorf("failed to start TCP listener: %v", err)
 		}
 		defer listener.Close()
+		opts.session.track(listener)
 
-		fmt.Printf("Listening on %s (TCP)\n", address)
+		// Scripts read the port chosen for port 0 from its own line
+		if port == "0" {
+			fmt.Println(listener.Addr().(*net.TCPAddr).Port)
+		}
+		fmt.Printf("Listening on %s (%s)\n", listener.Addr(), transport)
+		opts.logf("bound to %s", listener.Addr())
 
 		// Accept incoming connections
 		for {
@@ -107,41 +560,141 @@ func executeNCListen(port, protocol string) error {
 			if err != nil {
 				return fmt.Errorf("failed to accept connection: %v", err)
 			}
-			go handleTCPConnection(conn)
+			opts.logf("connection from %s to %s", conn.RemoteAddr(), conn.LocalAddr())
+			go handleTCPConnection(conn, opts)
 		}
-	} else if protocol == "udp" {
+	} else if opts.protocol == "udp" {
 		// Start UDP listener
-		conn, err := net.ListenPacket("udp", address)
+		conn, err := net.ListenPacket(opts.network("udp"), address)
 		if err != nil {
 			return fmt.Errorf("failed to start UDP listener: %v", err)
 		}
 		defer conn.Close()
+		opts.session.track(conn)
 
-		fmt.Printf("Listening on %s (UDP)\n", address)
+		if port == "0" {
+			fmt.Println(conn.LocalAddr().(*net.UDPAddr).Port)
+		}
+		fmt.Printf("Listening on %s (UDP)\n", conn.LocalAddr())
+		opts.logf("bound to %s", conn.LocalAddr())
 
 		// Handle UDP communication
-		handleUDPConnection(conn)
+		handleUDPConnection(conn, opts)
 	} else {
-		return fmt.Errorf("unsupported protocol: %s", protocol)
+		return fmt.Errorf("unsupported protocol: %s", opts.protocol)
+	}
+
+	return nil
+}
+
+// resolveBindAddress checks a --bind value, an IP address or an interface name, and
+// returns the address to listen on. Addresses must belong to one of the host's interfaces,
+// except for the wildcard addresses.
+func resolveBindAddress(bind string) (string, error) {
+	// A link-local address names its interface as a zone, e.g. fe80::1%eth0
+	addr, zone := splitZone(bind)
+	ip := net.ParseIP(addr)
+	if ip == nil && zone != "" {
+		return "", fmt.Errorf("invalid address %q", bind)
+	}
+	if ip == nil {
+		// An interface name binds to its primary address, IPv4 first
+		if addr, err := interfaceSourceAddress(bind, true); err == nil {
+			return addr, nil
+		}
+		return interfaceSourceAddress(bind, false)
+	}
+	if ip.IsUnspecified() {
+		return ip.String(), nil
 	}
 
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list local addresses: %v", err)
+	}
+	if zone != "" {
+		iface, err := net.InterfaceByName(zone)
+		if err != nil {
+			return "", fmt.Errorf("unknown interface %s: %v", zone, err)
+		}
+		addrs, err = iface.Addrs()
+		if err != nil {
+			return "", fmt.Errorf("failed to read addresses of %s: %v", zone, err)
+		}
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			if zone != "" {
+				return ip.String() + "%" + zone, nil
+			}
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%s is not an address of this host", bind)
+}
+
+// splitZone splits an IPv6 zone, the interface after "%" in fe80::1%eth0, from an address
+func splitZone(host string) (string, string) {
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
+
+// checkHostFamily rejects a literal address of the other family than the one chosen
+// with -4 or -6, and an IPv6 link-local address without a zone, which the system can't
+// route because every interface has its own link-local network. Host names pass; they are
+// resolved in the chosen family when dialing.
+func checkHostFamily(host, family string) error {
+	addr, zone := splitZone(host)
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	isIPv4 := ip.To4() != nil
+	switch {
+	case family == "4" && !isIPv4:
+		return fmt.Errorf("%s is an IPv6 address, but -4 was given", host)
+	case family == "6" && isIPv4:
+		return fmt.Errorf("%s is an IPv4 address, but -6 was given", host)
+	case !isIPv4 && ip.IsLinkLocalUnicast() && zone == "":
+		return fmt.Errorf("%s is a link-local address; add the interface it is on as a zone, e.g. %s%%eth0", host, host)
+	}
 	return nil
 }
 
 // handleTCPConnection handles an incoming TCP connection
-func handleTCPConnection(conn net.Conn) {
+func handleTCPConnection(conn net.Conn, opts ncOptions) {
+	opts.session.track(conn)
+	defer opts.session.untrack(conn)
 	defer conn.Close()
 
+	if err := tuneTCP(conn, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
 	fmt.Printf("Accepted connection from %s\n", conn.RemoteAddr())
 
-	// Copy data between the connection and stdout/stderr
-	go io.Copy(conn, os.Stdin) // Send data from stdin to the connection
-	io.Copy(os.Stdout, conn)   // Receive data from the connection and print it
+	// The TLS session runs over the TCP connection; a failed handshake only drops this client
+	if opts.tlsServer != nil {
+		tlsConn, err := startTLSServer(conn, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			return
+		}
+		defer tlsConn.Close()
+		conn = tlsConn
+	}
+
+	// Copy data between the connection and stdin/stdout
+	pipeConnection(conn, opts)
+
+	opts.logf("connection from %s closed", conn.RemoteAddr())
 }
 
 // handleUDPConnection handles UDP communication
-func handleUDPConnection(conn net.PacketConn) {
-	buf := make([]byte, 1024)
+func handleUDPConnection(conn net.PacketConn, opts ncOptions) {
+	buf := make([]byte, opts.bufferSize)
 
 	for {
 		n, addr, err := conn.ReadFrom(buf)
@@ -149,11 +702,19 @@ func handleUDPConnection(conn net.PacketConn) {
 			fmt.Printf("Error reading from UDP connection: %v\n", err)
 			return
 		}
+		opts.session.received.Add(int64(n))
 
-		fmt.Printf("Received %d bytes from %s: %s\n", n, addr, strings.TrimSpace(string(buf[:n])))
+		opts.logf("datagram of %d bytes from %s", n, addr)
+		if opts.hexdump {
+			// Binary payloads are shown byte for byte
+			fmt.Printf("Received %d bytes from %s:\n%s", n, addr, hex.Dump(buf[:n]))
+		} else {
+			fmt.Printf("Received %d bytes from %s: %s\n", n, addr, strings.TrimSpace(string(buf[:n])))
+		}
 
 		// Send response back
-		_, err = conn.WriteTo([]byte("Message received"), addr)
+		n, err = conn.WriteTo([]byte("Message received"), addr)
+		opts.session.sent.Add(int64(n))
 		if err != nil {
 			fmt.Printf("Error sending response: %v\n", err)
 			return
@@ -162,70 +723,163 @@ func handleUDPConnection(conn net.PacketConn) {
 }
 
 // executeTCP establishes a TCP connection to the specified address
-func executeTCP(address string, timeout time.Duration) error {
-	conn, err := net.DialTimeout("tcp", address, timeout)
+func executeTCP(address string, opts ncOptions) error {
+	opts.logf("connecting to %s (TCP, timeout %s)", address, opts.timeout)
+	conn, err := opts.session.dial(opts.network("tcp"), address, opts.timeout)
 	if err != nil {
-		return fmt.Errorf("failed to establish TCP connection: %v", err)
+		return fmt.Errorf("failed to establish TCP connection: %w", err)
 	}
 	defer conn.Close()
+	opts.session.track(conn)
+
+	opts.logf("resolved %s to %s", address, conn.RemoteAddr())
+	opts.logf("local address %s", conn.LocalAddr())
+	if err := tuneTCP(conn, opts); err != nil {
+		return err
+	}
+
+	// The TLS session runs over the TCP connection; closing it closes both
+	if opts.tls {
+		tlsConn, err := startTLSClient(conn, address, opts)
+		if err != nil {
+			return err
+		}
+		defer tlsConn.Close()
+		conn = tlsConn
+	}
+	fmt.Fprintf(os.Stderr, "Connected to %s (TCP)\n", address)
 
-	fmt.Printf("Connected to %s (TCP)\n", address)
+	// In banner mode, only read what the server volunteers
+	if opts.banner {
+		return readBanner(conn, opts)
+	}
+
+	// In probe mode, check the service with a single protocol exchange
+	if opts.probe != "" {
+		return runProbe(conn, address, opts)
+	}
+
+	// Exchange data until the remote side closes the connection
+	pipeConnection(conn, opts)
+
+	opts.logf("connection to %s closed", conn.RemoteAddr())
 	return nil
 }
 
-// executeTCPProxy establishes a TCP connection through a proxy to the specified address
-func executeTCPProxy(address string, timeout time.Duration, proxyURL string) error {
+// tuneTCP applies the socket options from the flags to a TCP connection. Keepalive
+// probes (--keepalive) are empty segments sent by the kernel, so they keep idle sessions
+// alive without adding anything to the data exchanged by the application.
+func tuneTCP(conn net.Conn, opts ncOptions) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
 
-	// Parse the proxy URL
-	proxy, err := url.Parse(proxyURL)
-	if err != nil {
-		return fmt.Errorf("invalid proxy URL: %v", err)
+	if err := tcpConn.SetNoDelay(opts.noDelay); err != nil {
+		return fmt.Errorf("failed to set TCP_NODELAY: %v", err)
+	}
+	if !opts.noDelay {
+		opts.logf("Nagle's algorithm enabled")
 	}
 
-	// Connect to the proxy
-	conn, err := net.DialTimeout("tcp", proxy.Host, timeout)
-	if err != nil {
-		return fmt.Errorf("failed to connect to proxy: %v", err)
+	if opts.keepAlive > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return fmt.Errorf("failed to enable TCP keepalive: %v", err)
+		}
+		if err := tcpConn.SetKeepAlivePeriod(opts.keepAlive); err != nil {
+			return fmt.Errorf("failed to set TCP keepalive period: %v", err)
+		}
+		opts.logf("TCP keepalive every %s", opts.keepAlive)
 	}
-	defer conn.Close()
 
-	// Send the HTTP CONNECT request to the proxy
-	connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", address, address)
-	_, err = conn.Write([]byte(connectReq))
+	// The MSS is only worth the syscall when it will be printed
+	if opts.verbose {
+		if send, recv, err := tcpSegmentSizes(tcpConn); err == nil {
+			opts.logf("MSS %d bytes (send), %d bytes (receive)", send, recv)
+		}
+	}
+	return nil
+}
+
+// readBanner performs a single read bounded by the timeout and prints whatever
+// greeting the server sent, without sending anything
+func readBanner(conn net.Conn, opts ncOptions) error {
+	conn.SetReadDeadline(opts.deadline())
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if n == 0 {
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("no banner received within %s: %v", opts.timeout, err)
+		}
+		return fmt.Errorf("no banner received within %s", opts.timeout)
+	}
+
+	opts.session.received.Add(int64(n))
+	fmt.Println(strings.TrimRight(string(buf[:n]), "\r\n"))
+	return nil
+}
+
+// executeTCPProxy establishes a TCP connection to the specified address through
+// the chain of proxies, in the order they were given
+func executeTCPProxy(address string, opts ncOptions) error {
+	chain, err := parseProxyChain(opts.proxies)
 	if err != nil {
-		return fmt.Errorf("failed to send CONNECT request: %v", err)
+		return err
 	}
 
-	// Read the proxy's response
-	reader := bufio.NewReader(conn)
-	resp, err := http.ReadResponse(reader, nil)
+	conn, err := dialProxyChain(chain, address, opts)
 	if err != nil {
-		return fmt.Errorf("failed to read proxy response: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
+	defer conn.Close()
 
-	// Check if the proxy successfully established the connection
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("proxy connection failed: %s", resp.Status)
+	// TLS runs end to end through the tunnel
+	if opts.tls {
+		tlsConn, err := startTLSClient(conn, address, opts)
+		if err != nil {
+			return err
+		}
+		defer tlsConn.Close()
+		conn = tlsConn
 	}
 
-	fmt.Printf("Connected to %s through HTTP proxy %s\n", address, proxyURL)
+	fmt.Fprintf(os.Stderr, "Connected to %s through %s\n", address, describeProxyChain(chain))
 
-	// You can now send and receive data over `conn`
-	// This is where you'd typically implement the netcat-like functionality for communication
-	// For example, using `conn.Read` and `conn.Write` to interact with the remote server
+	// In banner mode, only read what the server volunteers
+	if opts.banner {
+		return readBanner(conn, opts)
+	}
 
+	// In probe mode, check the service with a single protocol exchange
+	if opts.probe != "" {
+		return runProbe(conn, address, opts)
+	}
+
+	// Exchange data through the tunnel
+	pipeConnection(conn, opts)
+
+	opts.logf("tunnel to %s closed", address)
 	return nil
 }
 
 // executeUDP establishes a UDP connection to the specified address
-func executeUDP(address string, timeout time.Duration) error {
-	conn, err := net.DialTimeout("udp", address, timeout)
+func executeUDP(address string, opts ncOptions) error {
+	opts.logf("resolving %s (UDP, timeout %s)", address, opts.timeout)
+	conn, err := opts.session.dial(opts.network("udp"), address, opts.timeout)
 	if err != nil {
-		return fmt.Errorf("failed to establish UDP connection: %v", err)
+		return fmt.Errorf("failed to establish UDP connection: %w", err)
 	}
 	defer conn.Close()
+	opts.session.track(conn)
+
+	opts.logf("resolved %s to %s", address, conn.RemoteAddr())
+	opts.logf("local address %s", conn.LocalAddr())
+	fmt.Fprintf(os.Stderr, "Connected to %s (UDP)\n", address)
+
+	// Data read from stdin is sent as datagrams; replies are printed as they arrive
+	pipeConnection(conn, opts)
 
-	fmt.Printf("Connected to %s (UDP)\n", address)
+	opts.logf("closing socket to %s", conn.RemoteAddr())
 	return nil
 }
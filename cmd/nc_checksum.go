@@ -0,0 +1,49 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"os"
+)
+
+// checksumAlgorithms maps the --checksum values to their hash constructors
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// checksumWriter hashes the data written through it and counts its bytes, so that
+// one direction of a transfer can be verified against the source
+type checksumWriter struct {
+	algorithm string
+	h         hash.Hash
+	n         int64
+}
+
+// newChecksumWriter returns a writer hashing with the named algorithm
+func newChecksumWriter(algorithm string) (*checksumWriter, error) {
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum %q (use md5, sha1 or sha256)", algorithm)
+	}
+	return &checksumWriter{algorithm: algorithm, h: newHash()}, nil
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	c.h.Write(p)
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// report prints the digest of the data seen so far to stderr, in the same form as
+// sha256sum and friends so that it can be compared by eye or with cut
+func (c *checksumWriter) report(direction string) {
+	fmt.Fprintf(os.Stderr, "%x  %s %s (%d bytes)\n", c.h.Sum(nil), c.algorithm, direction, c.n)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ncProbe is a minimal protocol exchange used by --probe to check that a service is
+// alive and speaking the expected protocol
+type ncProbe struct {
+	greeting bool                      // the server speaks first; read its greeting before sending
+	request  func(host string) string  // the request sent to the server
+	last     func(line string) bool    // reports whether a line ends the server's reply
+	ok       func(reply []string) bool // reports whether the reply is what the protocol answers
+	quit     string                    // sent after the reply to end the session politely
+}
+
+// smtpLast reports whether an SMTP reply line is the last one: multi-line replies use
+// "250-" on every line but the last, which has "250 "
+func smtpLast(line string) bool {
+	return len(line) < 4 || line[3] != '-'
+}
+
+// ncProbes are the protocols --probe knows, by name
+var ncProbes = map[string]ncProbe{
+	"http": {
+		request: func(host string) string {
+			return fmt.Sprintf("HEAD / HTTP/1.1\r\nHost: %s\r\nUser-Agent: netro/%s\r\nConnection: close\r\n\r\n",
+				host, strings.TrimPrefix(Version, "v"))
+		},
+		// The headers end with an empty line
+		last: func(line string) bool { return line == "" },
+		ok:   func(reply []string) bool { return strings.HasPrefix(reply[0], "HTTP/") },
+	},
+	"smtp": {
+		greeting: true,
+		request:  func(string) string { return "EHLO netro.localdomain\r\n" },
+		last:     smtpLast,
+		ok:       func(reply []string) bool { return strings.HasPrefix(reply[len(reply)-1], "250") },
+		quit:     "QUIT\r\n",
+	},
+	"redis": {
+		request: func(string) string { return "*1\r\n$4\r\nPING\r\n" },
+		last:    func(string) bool { return true },
+		// An error such as "-NOAUTH" still comes from a live Redis
+		ok: func(reply []string) bool {
+			return strings.HasPrefix(reply[0], "+") || strings.HasPrefix(reply[0], "-")
+		},
+	},
+}
+
+// ncProbeNames returns the names accepted by --probe, sorted
+func ncProbeNames() []string {
+	names := make([]string, 0, len(ncProbes))
+	for name := range ncProbes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runProbe sends the probe's request over conn and prints the server's reply. It fails
+// when no reply arrives within the timeout or the reply is not what the protocol sends.
+func runProbe(conn net.Conn, address string, opts ncOptions) error {
+	probe := ncProbes[opts.probe]
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	// An IPv6 address is bracketed in a Host header, and its zone only matters locally
+	if addr, _ := splitZone(host); strings.Contains(addr, ":") {
+		host = "[" + addr + "]"
+	}
+	conn.SetDeadline(opts.deadline())
+	reader := bufio.NewReader(conn)
+
+	if probe.greeting {
+		greeting, err := readProbeReply(reader, probe.last, opts)
+		if err != nil {
+			return fmt.Errorf("no greeting received: %v", err)
+		}
+		printProbeReply(greeting)
+	}
+
+	request := probe.request(host)
+	opts.logf("sending %s probe: %q", opts.probe, request)
+	n, err := conn.Write([]byte(request))
+	opts.session.sent.Add(int64(n))
+	if err != nil {
+		return fmt.Errorf("failed to send probe: %v", err)
+	}
+
+	reply, err := readProbeReply(reader, probe.last, opts)
+	if err != nil {
+		return fmt.Errorf("no reply to %s probe: %v", opts.probe, err)
+	}
+	printProbeReply(reply)
+
+	if probe.quit != "" {
+		n, _ := conn.Write([]byte(probe.quit))
+		opts.session.sent.Add(int64(n))
+	}
+	if !probe.ok(reply) {
+		return fmt.Errorf("unexpected reply to %s probe: %q", opts.probe, reply[0])
+	}
+	return nil
+}
+
+// readProbeReply reads lines until one ends the reply. A reply cut short by the server
+// closing the connection still counts if at least one line arrived.
+func readProbeReply(reader *bufio.Reader, last func(string) bool, opts ncOptions) ([]string, error) {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		opts.session.received.Add(int64(len(line)))
+		if line != "" {
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			if last(line) {
+				return lines, nil
+			}
+		}
+		if err != nil {
+			if len(lines) > 0 {
+				return lines, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// printProbeReply prints the lines of a reply, leaving out the empty line ending HTTP headers
+func printProbeReply(lines []string) {
+	for _, line := range lines {
+		if line != "" {
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// parseProxyChain parses the --proxy URLs, in the order the hops are traversed
+func parseProxyChain(proxies []string) ([]*url.URL, error) {
+	chain := make([]*url.URL, 0, len(proxies))
+	for _, p := range proxies {
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %v", p, err)
+		}
+		switch u.Scheme {
+		case "http", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q in %s (use http or socks5)", u.Scheme, p)
+		}
+		if u.Port() == "" {
+			return nil, fmt.Errorf("proxy %s has no port", p)
+		}
+		chain = append(chain, u)
+	}
+	return chain, nil
+}
+
+// dialProxyChain connects to the first proxy and asks each hop in turn to connect
+// to the next one, so that every handshake runs over the previous hop's tunnel.
+// The returned connection is a tunnel to address.
+func dialProxyChain(chain []*url.URL, address string, opts ncOptions) (net.Conn, error) {
+	opts.logf("connecting to proxy %s", chain[0].Host)
+	conn, err := opts.session.dial(opts.network("tcp"), chain[0].Host, opts.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %v", chain[0].Host, err)
+	}
+	opts.session.track(conn)
+	opts.logf("connected to proxy at %s from %s", conn.RemoteAddr(), conn.LocalAddr())
+
+	// The tunnel rides on this connection, so it carries the keepalive and Nagle settings
+	if err := tuneTCP(conn, opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for i, hop := range chain {
+		target := address
+		if i+1 < len(chain) {
+			target = chain[i+1].Host
+		}
+
+		tunnel, err := proxyHandshake(conn, hop, target, opts)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %v", hop.Redacted(), err)
+		}
+		opts.logf("proxy %s connected to %s", hop.Host, target)
+		conn = tunnel
+	}
+	return conn, nil
+}
+
+// proxyHandshake asks the proxy at the other end of conn to connect to target
+func proxyHandshake(conn net.Conn, hop *url.URL, target string, opts ncOptions) (net.Conn, error) {
+	// Bound the handshake by the timeout, then clear the deadline for the data exchange
+	conn.SetDeadline(opts.deadline())
+	defer conn.SetDeadline(time.Time{})
+
+	if hop.Scheme == "http" {
+		return httpConnect(conn, target, opts)
+	}
+
+	var auth *proxy.Auth
+	if hop.User != nil {
+		password, _ := hop.User.Password()
+		auth = &proxy.Auth{User: hop.User.Username(), Password: password}
+	}
+	if hop.Scheme == "socks5" {
+		var err error
+		if target, err = resolveTarget(target, opts); err != nil {
+			return nil, err
+		}
+	}
+	dialer, err := proxy.SOCKS5("tcp", hop.Host, auth, connDialer{conn})
+	if err != nil {
+		return nil, err
+	}
+	return dialer.Dial(opts.network("tcp"), target)
+}
+
+// resolveTarget resolves the host name of target locally, in the family chosen with -4 or
+// -6 if any, for a socks5 hop, whose client resolves names as curl and ncat do. With
+// socks5h the proxy resolves them, and picks the family itself.
+func resolveTarget(target string, opts ncOptions) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || net.ParseIP(host) != nil {
+		return target, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(context.Background(), "ip"+opts.family, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// httpConnect opens a tunnel to target with an HTTP CONNECT request over conn
+func httpConnect(conn net.Conn, target string, opts ncOptions) (net.Conn, error) {
+	// Send the HTTP CONNECT request to the proxy
+	connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	if _, err := conn.Write([]byte(connectReq)); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT request: %v", err)
+	}
+
+	// Read the proxy's response
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proxy response: %v", err)
+	}
+	resp.Body.Close()
+
+	// Check if the proxy successfully established the connection
+	opts.logf("proxy replied %s", resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy connection failed: %s", resp.Status)
+	}
+
+	// Keep anything the reader already buffered past the response
+	return bufferedConn{Conn: conn, r: reader}, nil
+}
+
+// connDialer is a proxy.Dialer that hands out an already established connection,
+// letting a SOCKS handshake run over the previous hop of a chain
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d connDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+// describeProxyChain renders the hops of a chain for status messages
+func describeProxyChain(chain []*url.URL) string {
+	hops := make([]string, len(chain))
+	for i, hop := range chain {
+		hops[i] = hop.Redacted()
+	}
+	return strings.Join(hops, " -> ")
+}
@@ -0,0 +1,229 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Port states reported by -z. UDP ports that stay silent can't be told apart from
+// filtered ones, so they are "open|filtered".
+const (
+	portOpen         = "open"
+	portClosed       = "closed"
+	portFiltered     = "filtered"
+	portOpenFiltered = "open|filtered"
+)
+
+// tcpScanWorkers bounds the connection attempts in flight during a TCP scan
+const tcpScanWorkers = 64
+
+// portScanResult is the state of one scanned port
+type portScanResult struct {
+	port     int
+	state    string
+	reason   string
+	timedOut bool // filtered because nothing answered, rather than another error
+}
+
+// parsePortList parses the ports given to -z: a comma-separated list of ports and
+// ranges such as "22,80,8000-8010". The result is sorted and free of duplicates.
+func parsePortList(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil || lo < 1 || lo > 65535 {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		hi := lo
+		if isRange {
+			hi, err = strconv.Atoi(last)
+			if err != nil || hi < lo || hi > 65535 {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		for port := lo; port <= hi; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// scanPorts reports the state of each port on host, in port order. It succeeds when at
+// least one port may be open, so "nc -z" can gate scripts like a single connection would.
+func scanPorts(host string, ports []int, opts ncOptions) error {
+	var results []portScanResult
+	switch opts.protocol {
+	case "tcp":
+		results = scanTCPPorts(host, ports, opts)
+	case "udp":
+		results = scanUDPPorts(host, ports, opts)
+	default:
+		return fmt.Errorf("unsupported protocol: %s", opts.protocol)
+	}
+
+	reachable := 0
+	silent := 0
+	for _, result := range results {
+		address := net.JoinHostPort(host, strconv.Itoa(result.port))
+		fmt.Printf("%s/%s %s\n", address, opts.protocol, result.state)
+		opts.logf("%s: %s", address, result.reason)
+		switch result.state {
+		case portOpen:
+			reachable++
+		case portOpenFiltered:
+			reachable++
+			silent++
+		}
+	}
+
+	if silent > 0 {
+		fmt.Fprintf(os.Stderr, "Note: %d UDP port(s) sent no reply within %s. A UDP service may ignore a probe it "+
+			"doesn't understand, and a firewall may drop it, so these ports may be open or filtered.\n", silent, opts.timeout)
+	}
+	if reachable == 0 {
+		return fmt.Errorf("no open ports on %s", host)
+	}
+	return nil
+}
+
+// scanTCPPorts connects to each port, several at a time. A completed handshake means
+// open, a reset means closed and no answer within the timeout means filtered.
+func scanTCPPorts(host string, ports []int, opts ncOptions) []portScanResult {
+	results := make([]portScanResult, len(ports))
+	sem := make(chan struct{}, tcpScanWorkers)
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i, port int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = scanTCPPort(host, port, opts)
+		}(i, port)
+	}
+	wg.Wait()
+	return results
+}
+
+// scanTCPPort classifies a single TCP port
+func scanTCPPort(host string, port int, opts ncOptions) portScanResult {
+	conn, err := net.DialTimeout(opts.network("tcp"), net.JoinHostPort(host, strconv.Itoa(port)), opts.timeout)
+	if err == nil {
+		conn.Close()
+		return portScanResult{port: port, state: portOpen, reason: "connection accepted"}
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return portScanResult{port: port, state: portClosed, reason: "connection refused"}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return portScanResult{port: port, state: portFiltered, reason: "no answer within " + opts.timeout.String(), timedOut: true}
+	}
+	return portScanResult{port: port, state: portFiltered, reason: err.Error()}
+}
+
+// scanUDPPorts probes each port in turn. The probes are not sent in parallel because
+// hosts rate-limit the ICMP port-unreachable messages that mark a port closed; probing
+// quickly would make closed ports look silent.
+func scanUDPPorts(host string, ports []int, opts ncOptions) []portScanResult {
+	results := make([]portScanResult, 0, len(ports))
+	for _, port := range ports {
+		results = append(results, scanUDPPort(host, port, opts))
+	}
+	return results
+}
+
+// scanUDPPort sends a probe to a single UDP port and classifies it by what comes back:
+// a datagram means open, an ICMP port unreachable means closed, and silence means open
+// or filtered. The ICMP error is read through a connected socket, on which the kernel
+// reports it as a refused connection.
+func scanUDPPort(host string, port int, opts ncOptions) portScanResult {
+	result := portScanResult{port: port}
+	conn, err := net.DialTimeout(opts.network("udp"), net.JoinHostPort(host, strconv.Itoa(port)), opts.timeout)
+	if err != nil {
+		result.state, result.reason = portFiltered, err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(udpProbePayload(port)); err != nil {
+		if isPortUnreachable(err) {
+			result.state, result.reason = portClosed, "ICMP port unreachable"
+		} else {
+			result.state, result.reason = portFiltered, err.Error()
+		}
+		return result
+	}
+
+	conn.SetReadDeadline(time.Now().Add(opts.timeout))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	switch {
+	case err == nil:
+		result.state, result.reason = portOpen, fmt.Sprintf("%d-byte reply", n)
+	case isPortUnreachable(err):
+		result.state, result.reason = portClosed, "ICMP port unreachable"
+	default:
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			result.state, result.reason = portOpenFiltered, "no reply within "+opts.timeout.String()
+		} else {
+			result.state, result.reason = portFiltered, err.Error()
+		}
+	}
+	return result
+}
+
+// isPortUnreachable reports whether a UDP socket error carries an ICMP port unreachable:
+// Linux and the BSDs surface it as ECONNREFUSED, Windows as a connection reset
+func isPortUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// snmpGetSysDescr is an SNMPv1 get-request for sysDescr.0 with the "public" community
+var snmpGetSysDescr = []byte{
+	0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+	0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+	0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+}
+
+// udpProbePayload returns a request the service usually found on the port answers, so
+// an open port replies instead of silently dropping the probe. Other ports get a newline.
+func udpProbePayload(port int) []byte {
+	switch port {
+	case 53:
+		msg := new(dns.Msg)
+		msg.SetQuestion(".", dns.TypeNS)
+		if packed, err := msg.Pack(); err == nil {
+			return packed
+		}
+	case 123:
+		// NTPv4 client request: LI 0, version 4, mode 3
+		request := make([]byte, 48)
+		request[0] = 0x23
+		return request
+	case 161:
+		return snmpGetSysDescr
+	}
+	return []byte("\n")
+}
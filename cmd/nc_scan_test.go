@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePortList(t *testing.T) {
+	got, err := parsePortList("161, 53,123,20-22,21")
+	if err != nil {
+		t.Fatalf("parsePortList returned an unexpected error: %v", err)
+	}
+	expected := []int{20, 21, 22, 53, 123, 161}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parsePortList = %v, expected %v", got, expected)
+	}
+
+	for _, spec := range []string{"", "0", "65536", "http", "30-20", "1-"} {
+		if _, err := parsePortList(spec); err == nil {
+			t.Errorf("parsePortList(%q) should have failed", spec)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	input := "# dependencies\nexample.com 443\n\n  10.0.0.5   5432  \nlocalhost http\n"
+	got, err := parseTargets(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseTargets returned an unexpected error: %v", err)
+	}
+	expected := []ncTarget{{"example.com", 443}, {"10.0.0.5", 5432}, {"localhost", 80}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseTargets = %v, expected %v", got, expected)
+	}
+
+	for _, input := range []string{"example.com\n", "example.com 443 tcp\n", "example.com 0\n", "example.com 70000\n"} {
+		if _, err := parseTargets(strings.NewReader(input)); err == nil {
+			t.Errorf("parseTargets(%q) should have failed", input)
+		}
+	}
+}
+
+// closedUDPPort returns a loopback UDP port nothing listens on
+func closedUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+	return port
+}
+
+func TestScanUDPPortClosed(t *testing.T) {
+	port := closedUDPPort(t)
+	result := scanUDPPort("127.0.0.1", port, ncOptions{timeout: time.Second})
+	if result.state != portClosed {
+		t.Errorf("UDP port %d is %s (%s), want %s", port, result.state, result.reason, portClosed)
+	}
+}
+
+func TestScanUDPPortReplies(t *testing.T) {
+	echo, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	tests := []struct {
+		conn net.PacketConn
+		want string
+	}{
+		{echo, portOpen},
+		{silent, portOpenFiltered},
+	}
+	for _, tt := range tests {
+		port := tt.conn.LocalAddr().(*net.UDPAddr).Port
+		result := scanUDPPort("127.0.0.1", port, ncOptions{timeout: 200 * time.Millisecond})
+		if result.state != tt.want {
+			t.Errorf("UDP port %d is %s (%s), want %s", port, result.state, result.reason, tt.want)
+		}
+	}
+}
+
+func TestScanTCPPorts(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	openPort := ln.Addr().(*net.TCPAddr).Port
+
+	results := scanTCPPorts("127.0.0.1", []int{openPort, closedPort}, ncOptions{protocol: "tcp", timeout: time.Second})
+	want := []string{portOpen, portClosed}
+	for i, result := range results {
+		if result.state != want[i] {
+			t.Errorf("TCP port %d is %s (%s), want %s", result.port, result.state, result.reason, want[i])
+		}
+	}
+}
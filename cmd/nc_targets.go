@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ncTarget is one "host port" line read by --targets-from
+type ncTarget struct {
+	host string
+	port int
+}
+
+// targetTimeout is the state --targets-from reports for a target that didn't answer
+// within the timeout, and targetError the one for other failures such as an unknown host
+const (
+	targetTimeout = "timeout"
+	targetError   = "error"
+)
+
+// openTargets opens the --targets-from source: "-" is stdin, anything else a file
+func openTargets(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// parseTargets reads one "host port" pair per line. Blank lines and lines starting with
+// "#" are skipped; the port may be a number or a service name such as "https".
+func parseTargets(r io.Reader) ([]ncTarget, error) {
+	var targets []ncTarget
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"host port\", got %q", line, text)
+		}
+		port, err := strconv.Atoi(fields[1])
+		if err != nil {
+			port, err = net.LookupPort("tcp", fields[1])
+		}
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("line %d: invalid port %q", line, fields[1])
+		}
+		targets = append(targets, ncTarget{host: fields[0], port: port})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// checkTargets connects to every target, several at a time, and prints one line per
+// target in input order. It succeeds only when every target accepted the connection.
+func checkTargets(targets []ncTarget, opts ncOptions) error {
+	results := make([]portScanResult, len(targets))
+	sem := make(chan struct{}, tcpScanWorkers)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = scanTCPPort(target.host, target.port, opts)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, result := range results {
+		address := net.JoinHostPort(targets[i].host, strconv.Itoa(targets[i].port))
+		state := result.state
+		switch {
+		case state == portFiltered && result.timedOut:
+			state = targetTimeout
+		case state == portFiltered:
+			state = targetError
+		}
+		if state == targetError {
+			// Without the reason, an unknown host and a local failure would look alike
+			fmt.Printf("%s %s: %s\n", address, state, result.reason)
+		} else {
+			fmt.Printf("%s %s\n", address, state)
+			opts.logf("%s: %s", address, result.reason)
+		}
+		if state != portOpen {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets not open", failed, len(targets))
+	}
+	return nil
+}
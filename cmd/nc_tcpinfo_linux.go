@@ -0,0 +1,33 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpSegmentSizes returns the send and receive maximum segment sizes the kernel uses for
+// the connection, read from TCP_INFO
+func tcpSegmentSizes(conn *net.TCPConn) (send, recv uint32, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if sockErr != nil {
+		return 0, 0, fmt.Errorf("TCP_INFO: %v", sockErr)
+	}
+	return info.Snd_mss, info.Rcv_mss, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+)
+
+// tcpSegmentSizes is only implemented on Linux, where TCP_INFO exposes the segment sizes
+func tcpSegmentSizes(conn *net.TCPConn) (send, recv uint32, err error) {
+	return 0, 0, fmt.Errorf("the MSS is only available on Linux")
+}
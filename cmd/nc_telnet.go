@@ -0,0 +1,104 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Control characters handled by the telnet-style line editor
+const (
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyBackspace = 0x08
+	keyCtrlU     = 0x15
+	keyEscape    = 0x1d // Ctrl-], as in telnet, ends the session
+	keyDelete    = 0x7f
+)
+
+// stdinIsTerminal reports whether stdin is an interactive terminal
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// enterRawMode puts the terminal on stdin into raw mode and returns a function
+// that restores its previous state
+func enterRawMode() (func(), error) {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put terminal into raw mode: %v", err)
+	}
+	return func() { term.Restore(fd, oldState) }, nil
+}
+
+// telnetInput reads the raw-mode terminal, echoing and editing the current line
+// locally and sending it to the remote side with a CRLF ending when Enter is pressed.
+// Ctrl-C and Ctrl-D are sent to the remote side instead of acting locally; Ctrl-]
+// ends the session.
+func telnetInput(sent io.Writer) error {
+	fmt.Fprint(os.Stderr, "Escape character is '^]'.\r\n")
+
+	reader := bufio.NewReader(os.Stdin)
+	var line []byte
+
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil
+		}
+
+		switch b {
+		case keyEscape:
+			return nil
+		case '\r', '\n':
+			os.Stdout.WriteString("\r\n")
+			line = append(line, '\r', '\n')
+			if _, err := sent.Write(line); err != nil {
+				return err
+			}
+			line = line[:0]
+		case keyBackspace, keyDelete:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				os.Stdout.WriteString("\b \b")
+			}
+		case keyCtrlU:
+			for range line {
+				os.Stdout.WriteString("\b \b")
+			}
+			line = line[:0]
+		case keyCtrlC, keyCtrlD:
+			// Deliver control characters to the remote side immediately
+			os.Stdout.WriteString(fmt.Sprintf("^%c", '@'+b))
+			if _, err := sent.Write([]byte{b}); err != nil {
+				return err
+			}
+		default:
+			line = append(line, b)
+			os.Stdout.Write([]byte{b})
+		}
+	}
+}
+
+// crlfWriter translates bare LF line endings to CRLF, so that output is laid out
+// correctly while the terminal is in raw mode
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	translated := bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))
+	translated = bytes.ReplaceAll(translated, []byte("\r\r\n"), []byte("\r\n"))
+	if _, err := c.w.Write(translated); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConnectExitCode(t *testing.T) {
+	dialErr := func(err error) error {
+		return fmt.Errorf("failed to establish TCP connection: %w", &net.OpError{Op: "dial", Net: "tcp", Err: err})
+	}
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{dialErr(os.NewSyscallError("connect", syscall.ECONNREFUSED)), ncExitConnect},
+		{dialErr(os.NewSyscallError("connect", syscall.EHOSTUNREACH)), ncExitConnect},
+		{dialErr(&net.DNSError{Err: "no such host", Name: "nonexistent.invalid", IsNotFound: true}), ncExitResolve},
+		{dialErr(&net.DNSError{Err: "unknown port", Name: "tcp/http2", IsNotFound: true}), 1},
+		{dialErr(os.ErrDeadlineExceeded), ncExitTimeout},
+		{errors.New("probe failed"), 1},
+	}
+	for _, tt := range tests {
+		if got := connectExitCode(tt.err); got != tt.want {
+			t.Errorf("connectExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCheckHostFamily(t *testing.T) {
+	tests := []struct {
+		host    string
+		family  string
+		wantErr bool
+	}{
+		{"example.com", "6", false},
+		{"192.0.2.1", "", false},
+		{"192.0.2.1", "4", false},
+		{"192.0.2.1", "6", true},
+		{"2001:db8::1", "4", true},
+		{"2001:db8::1", "6", false},
+		{"fe80::1", "", true},
+		{"fe80::1%eth0", "6", false},
+		{"fe80::1%eth0", "4", true},
+	}
+	for _, tt := range tests {
+		err := checkHostFamily(tt.host, tt.family)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkHostFamily(%q, %q) = %v, want error: %v", tt.host, tt.family, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDialFamily(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	address := ln.Addr().String()
+
+	for _, tt := range []struct {
+		family  string
+		wantErr bool
+	}{
+		{"6", false},
+		{"", false},
+		{"4", true},
+	} {
+		opts := ncOptions{family: tt.family, session: newNCSession(), timeout: time.Second}
+		conn, err := opts.session.dial(opts.network("tcp"), address, opts.timeout)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dial %s with family %q: %v, want error: %v", address, tt.family, err, tt.wantErr)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+
+		// An address literal goes to the SOCKS proxy as is
+		if target, err := resolveTarget(address, opts); err != nil || target != address {
+			t.Errorf("resolveTarget(%q) with family %q = %q, %v", address, tt.family, target, err)
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	// socks5 hops get an address, looked up in the -4/-6 family if one is set
+	got, err := resolveTarget("localhost:80", ncOptions{family: "4"})
+	if err != nil || got != "127.0.0.1:80" {
+		t.Errorf("resolveTarget(localhost:80) with -4 = %q, %v, want 127.0.0.1:80", got, err)
+	}
+	got, err = resolveTarget("localhost:80", ncOptions{})
+	if host, _, _ := net.SplitHostPort(got); err != nil || !net.ParseIP(host).IsLoopback() {
+		t.Errorf("resolveTarget(localhost:80) = %q, %v, want a loopback address", got, err)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// startTLSClient runs a TLS handshake over conn. The certificate is verified against
+// --tls-servername when given, which is also sent as SNI, so a backend can be reached by
+// IP address while its certificate is checked against the name clients normally use;
+// otherwise the host part of address is used.
+func startTLSClient(conn net.Conn, address string, opts ncOptions) (*tls.Conn, error) {
+	serverName := opts.tlsServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: opts.tlsInsecure,
+	})
+	tlsConn.SetDeadline(opts.deadline())
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s (verifying %s) failed: %v", address, serverName, err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	state := tlsConn.ConnectionState()
+	opts.logf("%s, %s, server name %s", tlsVersionToString(state.Version), tls.CipherSuiteName(state.CipherSuite), serverName)
+	if len(state.PeerCertificates) > 0 {
+		opts.logf("server certificate: %s (issued by %s)", state.PeerCertificates[0].Subject, state.PeerCertificates[0].Issuer)
+	}
+	return tlsConn, nil
+}
+
+// loadTLSServerConfig reads the PEM certificate (which may be followed by its chain) and
+// key that the listener presents to clients
+func loadTLSServerConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate and key: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// startTLSServer runs the server side of a TLS handshake over an accepted connection and
+// prints what was negotiated, so clients can be checked against it
+func startTLSServer(conn net.Conn, opts ncOptions) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, opts.tlsServer)
+	tlsConn.SetDeadline(opts.deadline())
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	state := tlsConn.ConnectionState()
+	details := fmt.Sprintf("%s, %s", tlsVersionToString(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if state.ServerName != "" {
+		details += ", server name " + state.ServerName
+	}
+	fmt.Printf("TLS from %s: %s\n", conn.RemoteAddr(), details)
+	return tlsConn, nil
+}
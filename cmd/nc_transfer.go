@@ -0,0 +1,400 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ncSession tracks the open sockets and transfer counters of an nc run, so that
+// an interrupt can close everything cleanly and report what was transferred
+type ncSession struct {
+	mu          sync.Mutex
+	closers     map[io.Closer]struct{}
+	interrupted bool
+	done        chan struct{}
+	ctx         context.Context // canceled on interrupt, so connection attempts stop waiting
+	cancel      context.CancelFunc
+
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// newNCSession creates an empty session
+func newNCSession() *ncSession {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ncSession{
+		closers: make(map[io.Closer]struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// dial connects like net.DialTimeout, but gives up as soon as the session is interrupted,
+// when there is no connection yet to close
+func (s *ncSession) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	return dialer.DialContext(s.ctx, network, address)
+}
+
+// track registers a socket or listener to be closed on interrupt
+func (s *ncSession) track(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closers[c] = struct{}{}
+}
+
+// untrack removes a socket or listener that has already been closed
+func (s *ncSession) untrack(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.closers, c)
+}
+
+// handleInterrupt installs a SIGINT handler that closes every tracked socket and
+// prints the transfer statistics to stderr
+func (s *ncSession) handleInterrupt() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigCh
+
+		s.mu.Lock()
+		s.interrupted = true
+		s.cancel()
+		for c := range s.closers {
+			c.Close()
+		}
+		s.mu.Unlock()
+
+		s.printStats()
+		close(s.done)
+	}()
+}
+
+// wasInterrupted reports whether the session ended because of an interrupt. If so,
+// it waits for the handler to finish reporting before returning.
+func (s *ncSession) wasInterrupted() bool {
+	s.mu.Lock()
+	interrupted := s.interrupted
+	s.mu.Unlock()
+
+	if interrupted {
+		<-s.done
+	}
+	return interrupted
+}
+
+// isInterrupted reports whether an interrupt has been received, without waiting for
+// the handler to finish
+func (s *ncSession) isInterrupted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interrupted
+}
+
+// printStats prints the number of bytes sent and received to stderr
+func (s *ncSession) printStats() {
+	fmt.Fprintf(os.Stderr, "\nSent %d bytes, received %d bytes\n", s.sent.Load(), s.received.Load())
+}
+
+// countingWriter adds the number of bytes written through it to a counter
+type countingWriter struct {
+	w     io.Writer
+	count *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// bufferedConn is a connection whose initial bytes were already consumed into a
+// bufio.Reader (e.g. while parsing a proxy response) and must be read from it first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite half-closes the underlying connection when it supports it
+func (c bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// closeWriter is implemented by connections that support half-closing (e.g. *net.TCPConn)
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// sendLines copies r to w one line at a time, pausing for delay between lines to
+// emulate typing pace for servers that do not buffer input well
+func sendLines(w io.Writer, r io.Reader, delay time.Duration) error {
+	reader := bufio.NewReader(r)
+	first := true
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if !first {
+				time.Sleep(delay)
+			}
+			first = false
+			if _, werr := w.Write(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pipeConnection copies stdin to the connection and the connection to stdout
+// until the remote side closes, counting the bytes moved in each direction.
+// In send-only mode it returns once stdin is exhausted; in recv-only mode stdin
+// is never read and it returns on EOF from the connection.
+func pipeConnection(conn net.Conn, opts ncOptions) {
+	session := opts.session
+
+	var stdout io.Writer = os.Stdout
+	if opts.telnet {
+		restore, err := enterRawMode()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nc: %v\n", err)
+			return
+		}
+		defer restore()
+		stdout = crlfWriter{os.Stdout}
+	}
+
+	// Show received bytes as a hex+ASCII dump instead of passing them through
+	if opts.hexdump {
+		dumper := hex.Dumper(os.Stdout)
+		defer dumper.Close() // flushes the last partial line
+		stdout = dumper
+	}
+
+	// Put the time of arrival before each received line
+	if opts.timestamp {
+		stdout = newTimestampWriter(stdout)
+	}
+
+	// Throttle each direction to its --limit-rate; slow reads hold the peer back through
+	// TCP flow control, just as a slow link would
+	connWriter := newRateLimitedWriter(conn, opts.sendRate)
+	connReader := newRateLimitedReader(conn, opts.recvRate)
+
+	// Received data is also copied to the tee file; sent data too with --tee-both
+	var received io.Writer = stdout
+	var sent io.Writer = connWriter
+	if opts.tee != nil {
+		received = io.MultiWriter(stdout, opts.tee)
+		if opts.teeBoth {
+			sent = io.MultiWriter(connWriter, opts.tee)
+		}
+	}
+
+	// Hash each direction as it passes, reporting the digest once it completes
+	var sentSum, receivedSum *checksumWriter
+	if opts.checksum != "" {
+		sentSum, _ = newChecksumWriter(opts.checksum)
+		receivedSum, _ = newChecksumWriter(opts.checksum)
+		sent = io.MultiWriter(sent, sentSum)
+		received = io.MultiWriter(received, receivedSum)
+	}
+	// The digest of a transfer cut short by an error or Ctrl-C would pass for the real one
+	reportSum := func(sum *checksumWriter, direction string, err error) {
+		if sum == nil {
+			return
+		}
+		if err != nil || session.isInterrupted() {
+			fmt.Fprintf(os.Stderr, "nc: no %s checksum, the transfer did not complete\n", direction)
+			return
+		}
+		sum.report(direction)
+	}
+
+	// Open the session with the --init-send payload, then hand over to stdin
+	if len(opts.initSend) > 0 {
+		if _, err := (countingWriter{sent, &session.sent}).Write(opts.initSend); err != nil {
+			fmt.Fprintf(os.Stderr, "nc: failed to send --init-send payload: %v\n", err)
+			return
+		}
+		opts.logf("sent %d-byte --init-send payload", len(opts.initSend))
+	}
+
+	send := func() {
+		if opts.telnet {
+			if err := telnetInput(countingWriter{sent, &session.sent}); err != nil {
+				opts.logf("%v", err)
+			}
+			// Leaving the line editor ends the whole session
+			conn.Close()
+			return
+		}
+		var err error
+		if opts.sendDelay > 0 {
+			if err = sendLines(countingWriter{sent, &session.sent}, os.Stdin, opts.sendDelay); err != nil {
+				opts.logf("%v", err)
+			}
+		} else {
+			_, err = copyBuffered(countingWriter{sent, &session.sent}, os.Stdin, opts.bufferSize)
+		}
+		opts.logf("end of input, sent %d bytes", session.sent.Load())
+		reportSum(sentSum, "sent", err)
+	}
+
+	if opts.sendOnly {
+		send()
+		return
+	}
+
+	if !opts.recvOnly {
+		go func() {
+			send()
+
+			// Half-close TCP connections once stdin is exhausted so the peer sees EOF
+			if cw, ok := conn.(closeWriter); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+
+	// With --max-bytes, the connection reads as ended once the limit has been received
+	var source io.Reader = connReader
+	if opts.maxBytes > 0 {
+		source = io.LimitReader(connReader, opts.maxBytes)
+	}
+	n, err := copyBuffered(countingWriter{received, &session.received}, source, opts.bufferSize)
+	reportSum(receivedSum, "received", err)
+	if opts.maxBytes > 0 {
+		if n >= opts.maxBytes {
+			fmt.Fprintf(os.Stderr, "nc: stopped after receiving %d bytes (--max-bytes)\n", n)
+		} else {
+			fmt.Fprintf(os.Stderr, "nc: received %d bytes\n", n)
+		}
+	}
+}
+
+// copyBuffered copies src to dst through a buffer of the given size. Both sides are
+// wrapped so that io.CopyBuffer cannot bypass the buffer via ReaderFrom or WriterTo.
+func copyBuffered(dst io.Writer, src io.Reader, size int) (int64, error) {
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, size))
+}
+
+// lockedWriter serializes writes from concurrent goroutines, so that chunks sent and
+// received by several connections do not interleave mid-write in a shared tee file
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// timestampFormat is the ISO-8601 timestamp, with milliseconds, put before each line
+// received with --timestamp
+const timestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// timestampWriter prefixes every line written through it with the time its first byte
+// arrived, so that text protocol exchanges can be matched up with other logs
+type timestampWriter struct {
+	w       io.Writer
+	now     func() time.Time
+	midLine bool // the last write did not end with a newline
+}
+
+func newTimestampWriter(w io.Writer) *timestampWriter {
+	return &timestampWriter{w: w, now: time.Now}
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	var out []byte
+	for rest := p; len(rest) > 0; {
+		if !t.midLine {
+			out = append(out, t.now().Format(timestampFormat)...)
+			out = append(out, ' ')
+			t.midLine = true
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+			t.midLine = false
+		}
+		out = append(out, line...)
+		rest = rest[len(line):]
+	}
+	if _, err := t.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// decodeEscapes turns the escape sequences of a --init-send payload into the bytes they
+// stand for: \r, \n, \t, \0, \\ and \xNN for any byte
+func decodeEscapes(s string) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out = append(out, s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return nil, fmt.Errorf("trailing backslash")
+		}
+		i++
+		switch s[i] {
+		case 'r':
+			out = append(out, '\r')
+		case 'n':
+			out = append(out, '\n')
+		case 't':
+			out = append(out, '\t')
+		case '0':
+			out = append(out, 0)
+		case '\\':
+			out = append(out, '\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("incomplete \\x escape")
+			}
+			b, err := hex.DecodeString(s[i+1 : i+3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid \\x escape %q", s[i-1:i+3])
+			}
+			out = append(out, b[0])
+			i += 2
+		default:
+			return nil, fmt.Errorf("unknown escape \\%c", s[i])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDecodeEscapes(t *testing.T) {
+	got, err := decodeEscapes(`EHLO localhost\r\n\x00\xff\t\\`)
+	if err != nil {
+		t.Fatalf("decodeEscapes returned an unexpected error: %v", err)
+	}
+	if want := "EHLO localhost\r\n\x00\xff\t\\"; string(got) != want {
+		t.Errorf("decodeEscapes = %q, want %q", got, want)
+	}
+
+	for _, payload := range []string{`abc\`, `\x4`, `\xzz`, `\q`} {
+		if _, err := decodeEscapes(payload); err == nil {
+			t.Errorf("expected an error for %q", payload)
+		}
+	}
+}
+
+func TestTimestampWriter(t *testing.T) {
+	var out bytes.Buffer
+	tw := newTimestampWriter(&out)
+	tw.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+	// A line split across writes gets a single timestamp
+	for _, chunk := range []string{"220 ready\r\nEH", "LO ok\n", "", "250 a\n250 b"} {
+		if n, err := tw.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	want := "2024-05-01T12:00:00.000Z 220 ready\r\n2024-05-01T12:00:00.000Z EHLO ok\n" +
+		"2024-05-01T12:00:00.000Z 250 a\n2024-05-01T12:00:00.000Z 250 b"
+	if out.String() != want {
+		t.Errorf("timestampWriter wrote %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,35 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// waitForPort retries a TCP connect to address every retryInterval until it succeeds or
+// maxWait has passed, like wait-for-it.sh. Each attempt is bounded by the --timeout.
+func waitForPort(address string, retryInterval, maxWait time.Duration, opts ncOptions) error {
+	start := time.Now()
+	deadline := start.Add(maxWait)
+
+	for attempt := 1; ; attempt++ {
+		// Never let an attempt run past the overall deadline, even with --timeout 0
+		dialer := net.Dialer{Timeout: opts.timeout, Deadline: deadline}
+		conn, err := dialer.Dial(opts.network("tcp"), address)
+		if err == nil {
+			conn.Close()
+			fmt.Fprintf(os.Stderr, "%s is open after %s (attempt %d)\n", address, time.Since(start).Round(time.Millisecond), attempt)
+			return nil
+		}
+		opts.logf("attempt %d: %v", attempt, err)
+
+		if time.Until(deadline) < retryInterval {
+			return fmt.Errorf("%s did not open within %s (%d attempts): %v", address, maxWait, attempt, err)
+		}
+		time.Sleep(retryInterval)
+	}
+}
@@ -6,8 +6,15 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
 	"github.com/spf13/cobra"
 )
 
@@ -15,37 +22,557 @@ import (
 var netstatCmd = &cobra.Command{
 	Use:   "netstat",
 	Short: "Displays network connections, routing tables, interface statistics, and process details.",
-	Long:  `Netro's netstat command shows a list of active TCP and UDP connections, along with the process details (PID and process name) associated with each connection.`,
+	Long: `Netro's netstat command shows a list of active TCP and UDP connections, along with the process details (PID and process name) associated with each connection.
+Unix domain sockets are listed with their filesystem path; use --unix (-x) to show only those.
+Use --diagnose to check connection states for signs of trouble such as TIME_WAIT build-up.
+Use --by-interface to count connections and their states per local network interface.
+Use -t/-u to show only TCP or UDP sockets and -4/-6 to show only one address family.
+Use --output prometheus to print socket counts by protocol and state as Prometheus metrics, e.g. for
+node_exporter's textfile collector.
+Use --watch to poll the TCP/UDP connections and print a timestamped line for each state change
+(e.g. ESTABLISHED → CLOSE_WAIT → closed); --track host:port follows only connections to that remote end.
+Use --by-process to list the processes with the most sockets; with --rates, each process's TCP traffic
+is sampled over --interval and the top processes are sorted by bytes per second. The rates are
+approximate: they are read from the kernel's per-socket counters (Linux only), so UDP traffic and
+connections that open and close within the interval are not counted.
+Use --pid to show only the sockets of one process, with a PID/Program name column; --children also
+includes its descendants, e.g. an nginx master's workers, resolved once when the command starts.
+Use --orphans to show only listening sockets (TCP LISTEN, or unconnected UDP) that no running process
+owns: sockets held by the kernel itself, by a process that has exited, or by a zombie. Each is marked
+[ORPHAN] with the reason. Run it as root, since other users' sockets can't be attributed otherwise.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		showNetstatWithProcesses()
+		unixOnly, _ := cmd.Flags().GetBool("unix")
+		diagnose, _ := cmd.Flags().GetBool("diagnose")
+		queues, _ := cmd.Flags().GetBool("queues")
+		byInterface, _ := cmd.Flags().GetBool("by-interface")
+		tcpOnly, _ := cmd.Flags().GetBool("tcp")
+		udpOnly, _ := cmd.Flags().GetBool("udp")
+		ipv4Only, _ := cmd.Flags().GetBool("ipv4")
+		ipv6Only, _ := cmd.Flags().GetBool("ipv6")
+		output, _ := cmd.Flags().GetString("output")
+		watch, _ := cmd.Flags().GetBool("watch")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		track, _ := cmd.Flags().GetString("track")
+		byProcess, _ := cmd.Flags().GetBool("by-process")
+		rates, _ := cmd.Flags().GetBool("rates")
+		top, _ := cmd.Flags().GetInt("top")
+		pid, _ := cmd.Flags().GetInt32("pid")
+		children, _ := cmd.Flags().GetBool("children")
+		orphans, _ := cmd.Flags().GetBool("orphans")
+
+		if output != "table" && output != "prometheus" {
+			fatalf("Error: unsupported output format %q (use table or prometheus)", output)
+		}
+
+		if diagnose {
+			thresholds := diagnoseThresholds{}
+			thresholds.timeWaitRatio, _ = cmd.Flags().GetFloat64("time-wait-ratio")
+			thresholds.closeWait, _ = cmd.Flags().GetInt("close-wait-threshold")
+			thresholds.perProcess, _ = cmd.Flags().GetInt("process-threshold")
+			diagnoseConnections(thresholds)
+			return
+		}
+
+		if byInterface {
+			showConnectionsByInterface()
+			return
+		}
+
+		opts := netstatOptions{
+			unixOnly: unixOnly,
+			queues:   queues,
+			tcp:      tcpOnly,
+			udp:      udpOnly,
+			ipv4:     ipv4Only,
+			ipv6:     ipv6Only,
+			orphans:  orphans,
+		}
+		// Without root, sockets of other users' processes have no visible owner either
+		if orphans && os.Geteuid() != 0 {
+			fmt.Fprintln(os.Stderr, "Note: not running as root, so sockets owned by other users' processes are reported as orphaned too")
+		}
+		// Show only the sockets of one process, or of a process and its descendants
+		if children && pid <= 0 {
+			fatalf("Error: --children requires --pid")
+		}
+		if pid > 0 {
+			var err error
+			opts.pids, err = processTree(pid, children)
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+		}
+		// Rank the processes by their sockets, or by the traffic sampled over --interval
+		if rates && !byProcess {
+			fatalf("Error: --rates requires --by-process")
+		}
+		if byProcess {
+			if rates && interval <= 0 {
+				fatalf("Error: --interval must be positive")
+			}
+			if err := showProcesses(opts, rates, interval, top); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
+
+		// Follow connections over time and report their state transitions
+		if track != "" && !watch {
+			fatalf("Error: --track requires --watch")
+		}
+		if watch {
+			if interval <= 0 {
+				fatalf("Error: --interval must be positive")
+			}
+			var target *connectionTarget
+			if track != "" {
+				var err error
+				target, err = parseConnectionTarget(track)
+				if err != nil {
+					fatalf("Error: %v", err)
+				}
+			}
+			if err := watchConnections(opts, target, interval); err != nil {
+				log.Fatalf("Error watching connections: %v", err)
+			}
+			return
+		}
+
+		if output == "prometheus" {
+			printPrometheusMetrics(opts)
+			return
+		}
+		showNetstatWithProcesses(opts)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(netstatCmd)
+
+	// Define flags for the netstat command
+	netstatCmd.Flags().BoolP("unix", "x", false, "Show only Unix domain sockets")
+	netstatCmd.Flags().Bool("diagnose", false, "Summarize connection states and warn about likely problems")
+	netstatCmd.Flags().Float64("time-wait-ratio", 0.3, "Warn when TIME_WAIT sockets exceed this fraction of the ephemeral port range")
+	netstatCmd.Flags().Int("close-wait-threshold", 100, "Warn when CLOSE_WAIT sockets exceed this count")
+	netstatCmd.Flags().Int("process-threshold", 1000, "Warn when a single process holds more than this many connections")
+	netstatCmd.Flags().BoolP("queues", "Q", false, "Show the Recv-Q and Send-Q sizes of each socket (Linux only)")
+	netstatCmd.Flags().Bool("by-interface", false, "Group TCP/UDP connections by the local interface they are bound to")
+	netstatCmd.Flags().BoolP("tcp", "t", false, "Show only TCP sockets")
+	netstatCmd.Flags().BoolP("udp", "u", false, "Show only UDP sockets")
+	netstatCmd.Flags().BoolP("ipv4", "4", false, "Show only IPv4 sockets")
+	netstatCmd.Flags().BoolP("ipv6", "6", false, "Show only IPv6 sockets")
+	netstatCmd.Flags().StringP("output", "o", "table", "Output format: table or prometheus (connection counts by protocol and state)")
+	netstatCmd.Flags().BoolP("watch", "w", false, "Poll the connections and print a timestamped line whenever one appears, changes state or closes")
+	netstatCmd.Flags().Duration("interval", time.Second, "Polling interval for --watch, and sampling interval for --rates")
+	netstatCmd.Flags().String("track", "", "With --watch, follow only connections to this remote host:port (\":port\" for any host)")
+	netstatCmd.Flags().Bool("by-process", false, "List the processes holding TCP/UDP sockets, with their socket counts")
+	netstatCmd.Flags().Bool("rates", false, "With --by-process, sample each process's TCP traffic over --interval and sort by bytes per second (Linux only)")
+	netstatCmd.Flags().Int("top", 10, "With --by-process, show only this many processes (0 for all)")
+	netstatCmd.Flags().Int32("pid", 0, "Show only sockets owned by this process")
+	netstatCmd.Flags().Bool("children", false, "With --pid, also show sockets owned by the process's descendants")
+	netstatCmd.Flags().Bool("orphans", false, "Show only listening sockets whose owning process is missing, has exited or is a zombie")
+	netstatCmd.MarkFlagsMutuallyExclusive("orphans", "pid")
+	netstatCmd.MarkFlagsMutuallyExclusive("orphans", "unix")
+	netstatCmd.MarkFlagsMutuallyExclusive("orphans", "watch")
+	netstatCmd.MarkFlagsMutuallyExclusive("orphans", "by-process")
+	netstatCmd.MarkFlagsMutuallyExclusive("orphans", "output")
+	netstatCmd.MarkFlagsMutuallyExclusive("watch", "unix")
+	netstatCmd.MarkFlagsMutuallyExclusive("watch", "by-process")
+	netstatCmd.MarkFlagsMutuallyExclusive("by-process", "unix")
+	netstatCmd.MarkFlagsMutuallyExclusive("watch", "output")
+	netstatCmd.MarkFlagsMutuallyExclusive("unix", "tcp")
+	netstatCmd.MarkFlagsMutuallyExclusive("unix", "udp")
+	netstatCmd.MarkFlagsMutuallyExclusive("unix", "ipv4")
+	netstatCmd.MarkFlagsMutuallyExclusive("unix", "ipv6")
+	// The diagnosis and the per-interface summary cover every socket on the host
+	netstatCmd.MarkFlagsMutuallyExclusive("pid", "diagnose")
+	netstatCmd.MarkFlagsMutuallyExclusive("pid", "by-interface")
+	netstatCmd.MarkFlagsMutuallyExclusive("children", "diagnose")
+	netstatCmd.MarkFlagsMutuallyExclusive("children", "by-interface")
+	netstatCmd.MarkFlagsMutuallyExclusive("orphans", "diagnose")
+	netstatCmd.MarkFlagsMutuallyExclusive("orphans", "by-interface")
+}
+
+// netstatOptions holds the settings collected from the netstat command's flags
+type netstatOptions struct {
+	unixOnly bool
+	queues   bool
+	tcp      bool             // with udp unset, only TCP sockets
+	udp      bool             // with tcp unset, only UDP sockets
+	ipv4     bool             // with ipv6 unset, only IPv4 sockets
+	ipv6     bool             // with ipv4 unset, only IPv6 sockets
+	pids     map[int32]string // only sockets owned by these processes, mapped to their names; all if nil
+	orphans  bool             // only listening sockets without a live owning process
+}
+
+// ownedBy reports whether the options select sockets owned by the process
+func (o netstatOptions) ownedBy(pid int32) bool {
+	if o.pids == nil {
+		return true
+	}
+	_, ok := o.pids[pid]
+	return ok
+}
+
+// connectionKind returns the gopsutil connection kind selecting the sockets the options
+// ask for, e.g. "tcp6" for -t -6. Giving both -t and -u, or both -4 and -6, keeps both.
+func (o netstatOptions) connectionKind() string {
+	if o.unixOnly {
+		return "unix"
+	}
+	if !o.tcp && !o.udp && !o.ipv4 && !o.ipv6 {
+		return "all"
+	}
+
+	kind := "inet"
+	if o.tcp != o.udp {
+		kind = "udp"
+		if o.tcp {
+			kind = "tcp"
+		}
+	}
+	if o.ipv4 != o.ipv6 {
+		if o.ipv4 {
+			return kind + "4"
+		}
+		return kind + "6"
+	}
+	return kind
 }
 
 // showNetstatWithProcesses retrieves and prints active network connections along with associated processes
-func showNetstatWithProcesses() {
+func showNetstatWithProcesses(opts netstatOptions) {
+	kind := opts.connectionKind()
+
+	// Queue sizes come from a separate source and are matched up by address
+	var queues map[string]socketQueues
+	if opts.queues {
+		var err error
+		queues, err = readSocketQueues()
+		if err != nil {
+			log.Fatalf("Error retrieving socket queues: %v", err)
+		}
+	}
+
+	// Sockets of selected processes are labeled with their owner, as in netstat -p
+	owner := ""
+	if opts.pids != nil {
+		owner = " PID/Program name"
+	}
+	if opts.orphans {
+		owner = " Owner"
+	}
+
 	fmt.Println("Active Internet connections (servers and established)")
-	fmt.Printf("%-7s %-56s %-56s %-11s\n", "Proto", "Local Address", "Foreign Address", "State")
+	if opts.queues {
+		fmt.Printf("%-7s %6s %6s %-56s %-56s %-11s%s\n", "Proto", "Recv-Q", "Send-Q", "Local Address", "Foreign Address", "State", owner)
+	} else {
+		fmt.Printf("%-7s %-56s %-56s %-11s%s\n", "Proto", "Local Address", "Foreign Address", "State", owner)
+	}
 
-	connections, err := net.Connections("all")
+	connections, err := net.Connections(kind)
 	if err != nil {
 		log.Fatalf("Error retrieving network connections: %v", err)
 	}
 
 	for _, conn := range connections {
+		if !opts.ownedBy(conn.Pid) {
+			continue
+		}
+		// Only listening sockets without a live owner, each marked with the reason
+		reason := ""
+		if opts.orphans {
+			if !isListeningSocket(conn) {
+				continue
+			}
+			if reason = orphanReason(conn.Pid); reason == "" {
+				continue
+			}
+		}
 		protocol := getProtocolType(conn.Type) // Convert conn.Type to a string
 		localAddr := fmt.Sprintf("%s:%d", conn.Laddr.IP, conn.Laddr.Port)
 		remoteAddr := fmt.Sprintf("%s:%d", conn.Raddr.IP, conn.Raddr.Port)
 		state := conn.Status
 
+		// Unix sockets have no ports; gopsutil reports their path in Laddr.IP
+		if isUnixSocket(conn) {
+			protocol = "unix"
+			localAddr = conn.Laddr.IP
+			remoteAddr = conn.Raddr.IP
+		}
+
+		if opts.pids != nil {
+			state = fmt.Sprintf("%-11s %d/%s", state, conn.Pid, opts.pids[conn.Pid])
+		}
+		if reason != "" {
+			state = fmt.Sprintf("%-11s [ORPHAN] %s", state, reason)
+		}
+
 		// Display the connection details along with the process name and PID
+		if opts.queues {
+			recvQ, sendQ := "-", "-"
+			if q, ok := queues[queueKey(protocol, conn.Laddr.IP, conn.Laddr.Port, conn.Raddr.IP, conn.Raddr.Port)]; ok {
+				recvQ = strconv.FormatUint(q.recv, 10)
+				sendQ = strconv.FormatUint(q.send, 10)
+			}
+			fmt.Printf("%-7s %6s %6s %-56s %-56s %-11s\n", protocol, recvQ, sendQ, localAddr, remoteAddr, state)
+			continue
+		}
 		fmt.Printf("%-7s %-56s %-56s %-11s\n", protocol, localAddr, remoteAddr, state)
 	}
 }
 
+// queueKey identifies a socket by protocol and endpoints, to match queue sizes to connections
+func queueKey(proto, localIP string, localPort uint32, remoteIP string, remotePort uint32) string {
+	return fmt.Sprintf("%s %s:%d %s:%d", proto, localIP, localPort, remoteIP, remotePort)
+}
+
+// connectionSummary aggregates connection counts by state and by owning process
+type connectionSummary struct {
+	total   int
+	byState map[string]int
+	byPID   map[int32]int
+}
+
+// summarizeConnections counts the given connections by state and owning process
+func summarizeConnections(connections []net.ConnectionStat) connectionSummary {
+	summary := connectionSummary{
+		total:   len(connections),
+		byState: make(map[string]int),
+		byPID:   make(map[int32]int),
+	}
+	for _, conn := range connections {
+		summary.byState[conn.Status]++
+		if conn.Pid > 0 {
+			summary.byPID[conn.Pid]++
+		}
+	}
+	return summary
+}
+
+// printPrometheusMetrics prints the number of sockets per protocol and state in the
+// Prometheus text exposition format
+func printPrometheusMetrics(opts netstatOptions) {
+	connections, err := net.Connections(opts.connectionKind())
+	if err != nil {
+		log.Fatalf("Error retrieving network connections: %v", err)
+	}
+
+	groups := make(map[string][]net.ConnectionStat)
+	for _, conn := range connections {
+		if !opts.ownedBy(conn.Pid) {
+			continue
+		}
+		protocol := getProtocolType(conn.Type)
+		if isUnixSocket(conn) {
+			protocol = "unix"
+		}
+		groups[protocol] = append(groups[protocol], conn)
+	}
+
+	protocols := make([]string, 0, len(groups))
+	for protocol := range groups {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	fmt.Println("# HELP netro_connections Number of sockets by protocol and state.")
+	fmt.Println("# TYPE netro_connections gauge")
+	for _, protocol := range protocols {
+		summary := summarizeConnections(groups[protocol])
+
+		states := make([]string, 0, len(summary.byState))
+		for state := range summary.byState {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		for _, state := range states {
+			label := state
+			if label == "" {
+				label = "NONE" // UDP and Unix sockets are reported without a state
+			}
+			fmt.Printf("netro_connections{proto=%q,state=%q} %d\n", protocol, label, summary.byState[state])
+		}
+	}
+}
+
+// diagnoseThresholds are the limits above which --diagnose prints a warning
+type diagnoseThresholds struct {
+	timeWaitRatio float64 // fraction of the ephemeral port range
+	closeWait     int
+	perProcess    int
+}
+
+// diagnoseConnections summarizes TCP/UDP connection states and prints actionable warnings
+func diagnoseConnections(thresholds diagnoseThresholds) {
+	connections, err := net.Connections("inet")
+	if err != nil {
+		log.Fatalf("Error retrieving network connections: %v", err)
+	}
+	summary := summarizeConnections(connections)
+
+	fmt.Printf("Connections: %d\n", summary.total)
+	states := make([]string, 0, len(summary.byState))
+	for state := range summary.byState {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Printf("  %-12s %d\n", state, summary.byState[state])
+	}
+	fmt.Println()
+
+	warnings := 0
+
+	// Many TIME_WAIT sockets can exhaust the ephemeral ports used for outgoing connections
+	low, high := ephemeralPortRange()
+	portCount := high - low + 1
+	timeWait := summary.byState["TIME_WAIT"]
+	if float64(timeWait) > thresholds.timeWaitRatio*float64(portCount) {
+		warnings++
+		fmt.Printf("WARNING: %d sockets in TIME_WAIT, %.0f%% of the %d ephemeral ports (%d-%d).\n",
+			timeWait, 100*float64(timeWait)/float64(portCount), portCount, low, high)
+		fmt.Println("  Outgoing connections may fail with EADDRNOTAVAIL. Reuse connections (keep-alive/pooling),")
+		fmt.Println("  widen the ephemeral port range, or enable tcp_tw_reuse.")
+	}
+
+	// CLOSE_WAIT means the peer closed but the local application never did
+	closeWait := summary.byState["CLOSE_WAIT"]
+	if closeWait > thresholds.closeWait {
+		warnings++
+		fmt.Printf("WARNING: %d sockets in CLOSE_WAIT (threshold %d).\n", closeWait, thresholds.closeWait)
+		fmt.Println("  The owning application is not closing connections after the peer hangs up; check for leaks.")
+	}
+
+	// A single process holding a very large number of sockets may be leaking them or near its fd limit
+	pids := make([]int32, 0, len(summary.byPID))
+	for pid := range summary.byPID {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return summary.byPID[pids[i]] > summary.byPID[pids[j]] })
+	for _, pid := range pids {
+		count := summary.byPID[pid]
+		if count <= thresholds.perProcess {
+			continue
+		}
+		warnings++
+		name := "unknown"
+		if proc, err := process.NewProcess(pid); err == nil {
+			if n, err := proc.Name(); err == nil {
+				name = n
+			}
+		}
+		fmt.Printf("WARNING: process %d (%s) holds %d connections (threshold %d).\n", pid, name, count, thresholds.perProcess)
+		fmt.Println("  Check its connection pooling and open file limit (ulimit -n).")
+	}
+
+	if warnings == 0 {
+		fmt.Println("No problems detected.")
+	}
+}
+
+// anyInterface labels sockets bound to the wildcard address, which accept traffic on every interface
+const anyInterface = "* (any)"
+
+// interfaceAddresses maps each local IP address to the name of the interface it is assigned to
+func interfaceAddresses() (map[string]string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	byIP := make(map[string]string)
+	for _, iface := range interfaces {
+		for _, addr := range iface.Addrs {
+			// Addresses are reported in CIDR notation, e.g. "192.168.1.10/24"
+			ip, _, _ := strings.Cut(addr.Addr, "/")
+			byIP[ip] = iface.Name
+		}
+	}
+	return byIP, nil
+}
+
+// connectionInterface returns the interface a connection's local address belongs to
+func connectionInterface(localIP string, byIP map[string]string) string {
+	switch localIP {
+	case "", "*", "0.0.0.0", "::":
+		return anyInterface
+	}
+	if name, ok := byIP[localIP]; ok {
+		return name
+	}
+	// IPv4 peers of dual-stack sockets show up as IPv4-mapped IPv6 addresses
+	if name, ok := byIP[strings.TrimPrefix(localIP, "::ffff:")]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// showConnectionsByInterface prints the number of TCP/UDP connections and their states
+// for each local interface, so the load on each NIC of a multi-homed host is visible
+func showConnectionsByInterface() {
+	connections, err := net.Connections("inet")
+	if err != nil {
+		log.Fatalf("Error retrieving network connections: %v", err)
+	}
+	byIP, err := interfaceAddresses()
+	if err != nil {
+		log.Fatalf("Error retrieving network interfaces: %v", err)
+	}
+
+	groups := make(map[string][]net.ConnectionStat)
+	for _, conn := range connections {
+		name := connectionInterface(conn.Laddr.IP, byIP)
+		groups[name] = append(groups[name], conn)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%-16s %-11s %s\n", "Interface", "Connections", "States")
+	for _, name := range names {
+		summary := summarizeConnections(groups[name])
+
+		states := make([]string, 0, len(summary.byState))
+		for state := range summary.byState {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		for i, state := range states {
+			if state == "" {
+				state = "NONE" // UDP sockets have no state
+			}
+			states[i] = fmt.Sprintf("%s %d", state, summary.byState[states[i]])
+		}
+
+		fmt.Printf("%-16s %-11d %s\n", name, summary.total, strings.Join(states, ", "))
+	}
+}
+
+// ephemeralPortRange returns the local port range used for outgoing connections. It reads
+// the Linux setting and falls back to the IANA range on other systems.
+func ephemeralPortRange() (int, int) {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 {
+			low, errLow := strconv.Atoi(fields[0])
+			high, errHigh := strconv.Atoi(fields[1])
+			if errLow == nil && errHigh == nil && high >= low {
+				return low, high
+			}
+		}
+	}
+	return 49152, 65535
+}
+
+// isUnixSocket reports whether the connection is a Unix domain socket
+func isUnixSocket(conn net.ConnectionStat) bool {
+	return conn.Family == syscall.AF_UNIX
+}
+
 // getProtocolType converts the protocol type from uint32 to a human-readable string
 func getProtocolType(protocol uint32) string {
 	switch protocol {
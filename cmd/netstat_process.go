@@ -0,0 +1,204 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+// processTraffic is a process's share of the sockets, and of the TCP traffic sampled with --rates
+type processTraffic struct {
+	pid      int32
+	name     string
+	conns    int
+	sent     uint64
+	received uint64
+}
+
+// socketOwners maps each TCP socket, keyed by queueKey, to the process holding it, and
+// counts the TCP/UDP sockets of every process
+func socketOwners(opts netstatOptions) (map[string]int32, map[int32]*processTraffic, error) {
+	connections, err := psnet.Connections(opts.connectionKind())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve connections: %v", err)
+	}
+	owners := make(map[string]int32)
+	processes := make(map[int32]*processTraffic)
+	for _, conn := range connections {
+		if isUnixSocket(conn) || conn.Pid <= 0 || !opts.ownedBy(conn.Pid) {
+			continue
+		}
+		p, ok := processes[conn.Pid]
+		if !ok {
+			p = &processTraffic{pid: conn.Pid, name: "unknown"}
+			if proc, err := process.NewProcess(conn.Pid); err == nil {
+				if name, err := proc.Name(); err == nil {
+					p.name = name
+				}
+			}
+			processes[conn.Pid] = p
+		}
+		p.conns++
+		protocol := getProtocolType(conn.Type)
+		if protocol == "tcp" || protocol == "tcp6" {
+			owners[queueKey("tcp", conn.Laddr.IP, conn.Laddr.Port, conn.Raddr.IP, conn.Raddr.Port)] = conn.Pid
+		}
+	}
+	return owners, processes, nil
+}
+
+// processTree returns the process with its name and, with children, all of its
+// descendants, found by walking the process tree from it
+func processTree(pid int32, children bool) (map[int32]string, error) {
+	root, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, fmt.Errorf("no process with PID %d", pid)
+	}
+	tree := make(map[int32]string)
+	queue := []*process.Process{root}
+	for len(queue) > 0 {
+		proc := queue[0]
+		queue = queue[1:]
+		if _, seen := tree[proc.Pid]; seen {
+			continue
+		}
+		name, err := proc.Name()
+		if err != nil {
+			name = "unknown"
+		}
+		tree[proc.Pid] = name
+		if !children {
+			break
+		}
+		// Processes that exit meanwhile, or have no children, end their branch
+		kids, err := proc.Children()
+		if err == nil {
+			queue = append(queue, kids...)
+		}
+	}
+	return tree, nil
+}
+
+// sampleProcessTraffic reads the TCP byte counters twice, interval apart, and attributes
+// the growth of each socket's counters to the process holding it. This approximates each
+// process's bandwidth: only TCP is counted (UDP sockets keep no byte counters), sent bytes
+// are those acknowledged by the peer, and sockets that open or close between the two
+// samples are left out, since their counters span more than the interval.
+func sampleProcessTraffic(opts netstatOptions, interval time.Duration) (map[int32]*processTraffic, error) {
+	before, err := readTCPSocketBytes()
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(interval)
+	after, err := readTCPSocketBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	owners, processes, err := socketOwners(opts)
+	if err != nil {
+		return nil, err
+	}
+	for key, cur := range after {
+		pid, ok := owners[key]
+		if !ok {
+			continue
+		}
+		prev, ok := before[key]
+		if !ok {
+			continue
+		}
+		processes[pid].sent += counterDelta(cur.sent, prev.sent)
+		processes[pid].received += counterDelta(cur.received, prev.received)
+	}
+	return processes, nil
+}
+
+// showProcesses prints a table of the processes holding TCP/UDP sockets, sorted by
+// socket count, or with rates by the traffic sampled over the interval. At most top
+// processes are shown, all of them if top is 0.
+func showProcesses(opts netstatOptions, rates bool, interval time.Duration, top int) error {
+	var processes map[int32]*processTraffic
+	var err error
+	if rates {
+		processes, err = sampleProcessTraffic(opts, interval)
+	} else {
+		_, processes, err = socketOwners(opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	list := make([]*processTraffic, 0, len(processes))
+	for _, p := range processes {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if rates && a.sent+a.received != b.sent+b.received {
+			return a.sent+a.received > b.sent+b.received
+		}
+		if a.conns != b.conns {
+			return a.conns > b.conns
+		}
+		return a.pid < b.pid
+	})
+	if top > 0 && len(list) > top {
+		list = list[:top]
+	}
+
+	if !rates {
+		fmt.Printf("%-8s %-24s %6s\n", "PID", "Process", "Conns")
+		for _, p := range list {
+			fmt.Printf("%-8d %-24s %6d\n", p.pid, p.name, p.conns)
+		}
+		return nil
+	}
+
+	seconds := interval.Seconds()
+	fmt.Printf("%-8s %-24s %6s %12s %12s %12s\n", "PID", "Process", "Conns", "Recv", "Send", "Total")
+	for _, p := range list {
+		fmt.Printf("%-8d %-24s %6d %12s %12s %12s\n", p.pid, p.name, p.conns,
+			formatRate(p.received, seconds), formatRate(p.sent, seconds), formatRate(p.received+p.sent, seconds))
+	}
+	fmt.Printf("\nTCP traffic sampled over %s; UDP and connections opened or closed during the interval are not counted.\n", interval)
+	return nil
+}
+
+// isListeningSocket reports whether a TCP socket is listening or a UDP socket is bound
+// without being connected to a peer, i.e. waiting for anyone to talk to it
+func isListeningSocket(conn psnet.ConnectionStat) bool {
+	if isUnixSocket(conn) {
+		return false
+	}
+	switch getProtocolType(conn.Type) {
+	case "tcp", "tcp6":
+		return conn.Status == "LISTEN"
+	case "udp", "udp6":
+		return conn.Raddr.Port == 0
+	}
+	return false
+}
+
+// orphanReason tells why a socket has no live owner: nobody holds it (PID 0, as for
+// sockets the kernel keeps for itself), its process has exited, or it is a zombie that
+// can't close it. It is empty when a running process owns the socket.
+func orphanReason(pid int32) string {
+	if pid <= 0 {
+		return "no owning process"
+	}
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return fmt.Sprintf("PID %d has exited", pid)
+	}
+	if status, err := proc.Status(); err == nil && status == "Z" {
+		return fmt.Sprintf("PID %d is a zombie", pid)
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// socketQueues holds the receive and send queue sizes of a socket, in bytes
+type socketQueues struct {
+	recv uint64
+	send uint64
+}
+
+// readSocketQueues reads the Recv-Q/Send-Q of every TCP and UDP socket from /proc/net,
+// keyed by queueKey
+func readSocketQueues() (map[string]socketQueues, error) {
+	queues := make(map[string]socketQueues)
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		if err := parseProcNetQueues("/proc/net/"+proto, strings.TrimSuffix(proto, "6"), queues); err != nil {
+			return nil, err
+		}
+	}
+	return queues, nil
+}
+
+// parseProcNetQueues adds the queue sizes listed in a /proc/net/{tcp,udp}[6] file to queues
+func parseProcNetQueues(path, proto string, queues map[string]socketQueues) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // e.g. IPv6 disabled
+		}
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // skip the header line
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		localIP, localPort, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		remoteIP, remotePort, err := parseProcNetAddr(fields[2])
+		if err != nil {
+			continue
+		}
+		txQueue, rxQueue, ok := strings.Cut(fields[4], ":")
+		if !ok {
+			continue
+		}
+		send, _ := strconv.ParseUint(txQueue, 16, 64)
+		recv, _ := strconv.ParseUint(rxQueue, 16, 64)
+
+		queues[queueKey(proto, localIP, localPort, remoteIP, remotePort)] = socketQueues{recv: recv, send: send}
+	}
+	return scanner.Err()
+}
+
+// parseProcNetAddr decodes an "ADDR:PORT" pair from /proc/net, where the address is
+// hex encoded as host-order 32-bit words
+func parseProcNetAddr(s string) (string, uint32, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed address %q", s)
+	}
+	raw, err := hex.DecodeString(addrHex)
+	if err != nil || len(raw)%4 != 0 {
+		return "", 0, fmt.Errorf("malformed address %q", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed port %q", s)
+	}
+
+	// Each 32-bit word is stored in the machine's byte order (little-endian on common platforms)
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return ip.String(), uint32(port), nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+
+package cmd
+
+import "fmt"
+
+// socketQueues holds the receive and send queue sizes of a socket, in bytes
+type socketQueues struct {
+	recv uint64
+	send uint64
+}
+
+// readSocketQueues is only implemented on Linux, where /proc/net exposes queue sizes
+func readSocketQueues() (map[string]socketQueues, error) {
+	return nil, fmt.Errorf("socket queue sizes are only available on Linux")
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketBytes holds the bytes a TCP socket has sent (and had acknowledged) and received
+type socketBytes struct {
+	sent     uint64
+	received uint64
+}
+
+// inetDiagInfo is the INET_DIAG_INFO attribute, which carries the socket's tcp_info
+const inetDiagInfo = 2
+
+// inetDiagMsgLen is the size of struct inet_diag_msg, which precedes the attributes
+const inetDiagMsgLen = 72
+
+// readTCPSocketBytes asks the kernel's sock_diag interface for the tcp_info of every TCP
+// socket and returns their byte counters, keyed by queueKey. Kernels older than 4.2 don't
+// report the counters; their sockets are left out.
+func readTCPSocketBytes() (map[string]socketBytes, error) {
+	counters := make(map[string]socketBytes)
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		if err := dumpTCPSockets(family, counters); err != nil {
+			return nil, err
+		}
+	}
+	return counters, nil
+}
+
+// dumpTCPSockets sends a SOCK_DIAG_BY_FAMILY dump request for one address family and adds
+// the byte counters of the sockets in the reply to counters
+func dumpTCPSockets(family uint8, counters map[string]socketBytes) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_INET_DIAG)
+	if err != nil {
+		return fmt.Errorf("failed to open sock_diag socket: %v", err)
+	}
+	defer unix.Close(fd)
+
+	// struct nlmsghdr followed by struct inet_diag_req_v2: family, protocol, extensions,
+	// padding, state mask and a zeroed socket ID
+	req := make([]byte, unix.NLMSG_HDRLEN+56)
+	binary.NativeEndian.PutUint32(req[0:], uint32(len(req)))
+	binary.NativeEndian.PutUint16(req[4:], unix.SOCK_DIAG_BY_FAMILY)
+	binary.NativeEndian.PutUint16(req[6:], unix.NLM_F_REQUEST|unix.NLM_F_DUMP)
+	body := req[unix.NLMSG_HDRLEN:]
+	body[0] = family
+	body[1] = unix.IPPROTO_TCP
+	body[2] = 1 << (inetDiagInfo - 1)
+	binary.NativeEndian.PutUint32(body[4:], ^uint32(0)) // every state
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to query sock_diag: %v", err)
+	}
+
+	buf := make([]byte, os.Getpagesize()*8)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read sock_diag reply: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("malformed sock_diag reply: %v", err)
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case unix.NLMSG_DONE:
+				return nil
+			case unix.NLMSG_ERROR:
+				if len(msg.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(msg.Data)); errno != 0 {
+						return fmt.Errorf("sock_diag: %v", syscall.Errno(errno))
+					}
+				}
+				return nil
+			case unix.SOCK_DIAG_BY_FAMILY:
+				if key, bytes, ok := parseInetDiagMsg(msg.Data); ok {
+					counters[key] = bytes
+				}
+			}
+		}
+	}
+}
+
+// parseInetDiagMsg decodes a struct inet_diag_msg and its tcp_info attribute into the
+// socket's queueKey and byte counters
+func parseInetDiagMsg(data []byte) (string, socketBytes, bool) {
+	if len(data) < inetDiagMsgLen {
+		return "", socketBytes{}, false
+	}
+	addrLen := net.IPv4len
+	if data[0] == unix.AF_INET6 {
+		addrLen = net.IPv6len
+	}
+	localPort := uint32(binary.BigEndian.Uint16(data[4:]))
+	remotePort := uint32(binary.BigEndian.Uint16(data[6:]))
+	localIP := net.IP(append([]byte(nil), data[8:8+addrLen]...))
+	remoteIP := net.IP(append([]byte(nil), data[24:24+addrLen]...))
+	key := queueKey("tcp", localIP.String(), localPort, remoteIP.String(), remotePort)
+
+	// The attributes follow the message, each a struct rtattr with a 4-byte aligned payload
+	ackedOff := int(unsafe.Offsetof(unix.TCPInfo{}.Bytes_acked))
+	receivedOff := int(unsafe.Offsetof(unix.TCPInfo{}.Bytes_received))
+	attrs := data[inetDiagMsgLen:]
+	for len(attrs) >= unix.SizeofRtAttr {
+		attrLen := int(binary.NativeEndian.Uint16(attrs[0:]))
+		attrType := binary.NativeEndian.Uint16(attrs[2:])
+		if attrLen < unix.SizeofRtAttr || attrLen > len(attrs) {
+			break
+		}
+		payload := attrs[unix.SizeofRtAttr:attrLen]
+		if attrType == inetDiagInfo && len(payload) >= receivedOff+8 {
+			return key, socketBytes{
+				sent:     binary.NativeEndian.Uint64(payload[ackedOff:]),
+				received: binary.NativeEndian.Uint64(payload[receivedOff:]),
+			}, true
+		}
+		attrLen = (attrLen + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+		if attrLen > len(attrs) {
+			break
+		}
+		attrs = attrs[attrLen:]
+	}
+	return "", socketBytes{}, false
+}
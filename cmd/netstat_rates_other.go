@@ -0,0 +1,20 @@
+//go:build !linux
+
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+
+package cmd
+
+import "fmt"
+
+// socketBytes holds the bytes a TCP socket has sent (and had acknowledged) and received
+type socketBytes struct {
+	sent     uint64
+	received uint64
+}
+
+// readTCPSocketBytes is only implemented on Linux, where sock_diag reports per-socket byte counts
+func readTCPSocketBytes() (map[string]socketBytes, error) {
+	return nil, fmt.Errorf("per-socket byte counts are only available on Linux")
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/net"
+)
+
+// connectionTarget selects connections by their remote address for --track
+type connectionTarget struct {
+	ips  map[string]bool // remote IPs to match; any IP if nil
+	port uint32          // remote port to match
+}
+
+// parseConnectionTarget parses a host:port for --track. The host may be a name, which
+// is resolved once, an IP address or empty to match any remote host.
+func parseConnectionTarget(spec string) (*connectionTarget, error) {
+	host, portStr, err := net.SplitHostPort(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --track %q (use host:port): %v", spec, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in --track %q", spec)
+	}
+
+	target := &connectionTarget{port: uint32(port)}
+	if host == "" || host == "*" {
+		return target, nil
+	}
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+	target.ips = make(map[string]bool)
+	for _, ip := range addrs {
+		target.ips[ip.String()] = true
+	}
+	return target, nil
+}
+
+// matches reports whether a connection's remote end is the target
+func (t *connectionTarget) matches(conn psnet.ConnectionStat) bool {
+	if conn.Raddr.Port != t.port {
+		return false
+	}
+	if t.ips == nil {
+		return true
+	}
+	ip := net.ParseIP(conn.Raddr.IP)
+	return ip != nil && t.ips[ip.String()]
+}
+
+// connectionStates takes a snapshot of the TCP/UDP connections the options select,
+// mapping each connection's protocol and endpoints to its state
+func connectionStates(opts netstatOptions, target *connectionTarget) (map[string]string, error) {
+	connections, err := psnet.Connections(opts.connectionKind())
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]string)
+	for _, conn := range connections {
+		if isUnixSocket(conn) || !opts.ownedBy(conn.Pid) {
+			continue
+		}
+		if target != nil && !target.matches(conn) {
+			continue
+		}
+		protocol := getProtocolType(conn.Type)
+		key := fmt.Sprintf("%s %s → %s", protocol,
+			net.JoinHostPort(conn.Laddr.IP, strconv.Itoa(int(conn.Laddr.Port))),
+			net.JoinHostPort(conn.Raddr.IP, strconv.Itoa(int(conn.Raddr.Port))))
+		state := conn.Status
+		if state == "" {
+			state = "NONE"
+		}
+		states[key] = state
+	}
+	return states, nil
+}
+
+// stateChange is a connection whose state differs between two snapshots. A connection
+// that appeared has an empty from, one that disappeared an empty to.
+type stateChange struct {
+	conn     string
+	from, to string
+}
+
+// diffConnectionStates returns the changes between two snapshots, sorted by connection
+func diffConnectionStates(prev, cur map[string]string) []stateChange {
+	var changes []stateChange
+	for conn, state := range cur {
+		if prev[conn] != state {
+			changes = append(changes, stateChange{conn: conn, from: prev[conn], to: state})
+		}
+	}
+	for conn, state := range prev {
+		if _, ok := cur[conn]; !ok {
+			changes = append(changes, stateChange{conn: conn, from: state})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].conn < changes[j].conn })
+	return changes
+}
+
+// watchConnections polls the connections every interval and prints a timestamped line
+// for each one that appears, changes state or goes away, until interrupted
+func watchConnections(opts netstatOptions, target *connectionTarget, interval time.Duration) error {
+	prev, err := connectionStates(opts, target)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve connections: %v", err)
+	}
+
+	// Start from the current states, so later lines only show transitions
+	now := time.Now().Format("15:04:05.000")
+	for _, change := range diffConnectionStates(nil, prev) {
+		fmt.Printf("%s %s: %s\n", now, change.conn, change.to)
+	}
+	fmt.Printf("Watching %d connections every %s (Ctrl-C to stop)\n", len(prev), interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cur, err := connectionStates(opts, target)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve connections: %v", err)
+		}
+		now := time.Now().Format("15:04:05.000")
+		for _, change := range diffConnectionStates(prev, cur) {
+			from, to := change.from, change.to
+			if from == "" {
+				from = "new"
+			}
+			if to == "" {
+				to = "closed"
+			}
+			fmt.Printf("%s %s: %s → %s\n", now, change.conn, from, to)
+		}
+		prev = cur
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import "testing"
+
+func TestDiffConnectionStates(t *testing.T) {
+	prev := map[string]string{
+		"tcp a → b": "ESTABLISHED",
+		"tcp c → d": "CLOSE_WAIT",
+		"tcp e → f": "ESTABLISHED",
+	}
+	cur := map[string]string{
+		"tcp a → b": "CLOSE_WAIT",
+		"tcp e → f": "ESTABLISHED",
+		"tcp g → h": "SYN_SENT",
+	}
+
+	want := []stateChange{
+		{conn: "tcp a → b", from: "ESTABLISHED", to: "CLOSE_WAIT"},
+		{conn: "tcp c → d", from: "CLOSE_WAIT"},
+		{conn: "tcp g → h", to: "SYN_SENT"},
+	}
+	got := diffConnectionStates(prev, cur)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -4,11 +4,17 @@ Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-ping/ping"
+	probing "github.com/prometheus-community/pro-bing"
 	"github.com/spf13/cobra"
 )
 
@@ -17,22 +23,85 @@ var pingCmd = &cobra.Command{
 	Use:   "ping [host]",
 	Short: "Ping a host to measure network latency",
 	Long: `Ping sends ICMP echo requests to network hosts to determine 
-their availability and measure the time it takes for packets to travel to the host and back (round-trip time).`,
+their availability and measure the time it takes for packets to travel to the host and back (round-trip time).
+For scripts and health checks, -q/--summary-only prints only the statistics block and --silent prints
+nothing at all. Both exit non-zero when the packet loss reaches --loss-threshold percent (by default
+100, i.e. when no reply arrives); giving --loss-threshold applies the same check to the normal output.
+The TTL of each reply is shown, marked when it differs from the previous reply's, and the statistics
+estimate the length of the return path from it. A TTL that changes during the run means the return path
+changed (route flapping or load balancing); an unusually long return path may mean asymmetric routing.`,
 	Args: cobra.ExactArgs(1), // One argument required, the host to ping
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		host := args[0]
 
 		// Fetch flags
 		count, _ := cmd.Flags().GetInt("count")
 		timeout, _ := cmd.Flags().GetDuration("timeout")
 		interval, _ := cmd.Flags().GetDuration("interval")
+		ttl, _ := cmd.Flags().GetInt("ttl")
+		tos, _ := cmd.Flags().GetInt("tos")
+		if cmd.Flags().Changed("dscp") {
+			dscp, _ := cmd.Flags().GetInt("dscp")
+			if dscp < 0 || dscp > 63 {
+				fatalf("Error executing ping: DSCP value must be between 0 and 63, got %d", dscp)
+			}
+			// DSCP occupies the upper six bits of the ToS byte
+			tos = dscp << 2
+		}
+
+		lossWindow, _ := cmd.Flags().GetInt("loss-window")
+		source, _ := cmd.Flags().GetString("source")
+		iface, _ := cmd.Flags().GetString("interface")
+		histogram, _ := cmd.Flags().GetBool("histogram")
+		silent, _ := cmd.Flags().GetBool("silent")
+		summaryOnly, _ := cmd.Flags().GetBool("summary-only")
+		lossThreshold, _ := cmd.Flags().GetFloat64("loss-threshold")
+		if lossThreshold < 0 || lossThreshold > 100 {
+			fatalf("Error executing ping: --loss-threshold must be between 0 and 100, got %g", lossThreshold)
+		}
+
+		// Raw ICMP sockets need root (or CAP_NET_RAW); unless told otherwise, only
+		// use them when they can be opened and fall back to unprivileged ICMP sockets
+		privileged := rawICMPAllowed()
+		if cmd.Flags().Changed("privileged") {
+			privileged, _ = cmd.Flags().GetBool("privileged")
+		}
+
+		opts := pingOptions{
+			count:      count,
+			timeout:    timeout,
+			interval:   interval,
+			ttl:        ttl,
+			tos:        tos,
+			lossWindow: lossWindow,
+			source:     source,
+			iface:      iface,
+			privileged: privileged,
+			histogram:  histogram,
+			silent:     silent,
+			summary:    summaryOnly,
+		}
+		// The scripting modes, or an explicit threshold, turn packet loss into an exit code
+		if silent || summaryOnly || cmd.Flags().Changed("loss-threshold") {
+			opts.lossThreshold = lossThreshold
+			opts.checkLoss = true
+		}
 
 		// Execute ping logic
-		err := executePing(host, count, timeout, interval)
+		err := executePing(host, opts)
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = silent
+			return err
+		}
 		if err != nil {
-			fmt.Printf("Error executing ping: %v\n", err)
-			os.Exit(1)
+			if !silent {
+				fmt.Printf("Error executing ping: %v\n", err)
+			}
+			exitCommand(1, err)
 		}
+		return nil
 	},
 }
 
@@ -43,24 +112,149 @@ func init() {
 	pingCmd.Flags().IntP("count", "c", 4, "Number of packets to send")
 	pingCmd.Flags().DurationP("timeout", "t", 5*time.Second, "Timeout duration for each ping request")
 	pingCmd.Flags().DurationP("interval", "i", 1*time.Second, "Interval between successive packets")
+	pingCmd.Flags().Int("ttl", 64, "IP time-to-live (hop limit for IPv6) of outgoing packets")
+	pingCmd.Flags().Int("tos", 0, "Type-of-service byte (traffic class for IPv6) of outgoing packets")
+	pingCmd.Flags().Int("dscp", 0, "DSCP code point (0-63) of outgoing packets; sets the upper six bits of the TOS byte, so it can't be combined with --tos")
+	pingCmd.MarkFlagsMutuallyExclusive("tos", "dscp")
+	pingCmd.Flags().Int("loss-window", 10, "Report jitter and packet loss over each window of this many packets (0 to disable)")
+	pingCmd.Flags().StringP("source", "S", "", "Source address of outgoing packets")
+	pingCmd.Flags().StringP("interface", "I", "", "Send packets out of this interface, using its address as the source")
+	pingCmd.MarkFlagsMutuallyExclusive("source", "interface")
+	pingCmd.Flags().Bool("histogram", false, "Print a histogram of round-trip times after the run")
+	pingCmd.Flags().Bool("silent", false, "Print nothing; report the result only through the exit code (see --loss-threshold)")
+	pingCmd.Flags().BoolP("summary-only", "q", false, "Print only the final statistics block")
+	pingCmd.MarkFlagsMutuallyExclusive("silent", "summary-only")
+	pingCmd.Flags().Float64("loss-threshold", 100, "Exit non-zero when the packet loss in percent reaches this value; applies to --silent and --summary-only unless given")
+	pingCmd.Flags().Bool("privileged", false, "Use raw ICMP sockets (requires root or CAP_NET_RAW); defaults to true when raw sockets can be opened")
+}
+
+// pingOptions holds the settings collected from the ping command's flags
+type pingOptions struct {
+	count      int
+	timeout    time.Duration
+	interval   time.Duration
+	ttl        int
+	tos        int
+	lossWindow int
+	source     string // source IP address, empty for the system's choice
+	iface      string // interface to send from, empty for the routing table's choice
+	privileged bool   // raw ICMP sockets instead of unprivileged datagram sockets
+	histogram  bool   // print the round-trip time distribution at the end
+	silent     bool   // print nothing
+	summary    bool   // print only the statistics block
+
+	checkLoss     bool    // fail when the packet loss reaches lossThreshold
+	lossThreshold float64 // percent
+}
+
+// minUnprivilegedInterval is the shortest interval ping allows without raw sockets,
+// matching the limit the system ping enforces for unprivileged users
+const minUnprivilegedInterval = 200 * time.Millisecond
+
+// rawICMPAllowed reports whether this process may open raw ICMP sockets: as root, or
+// with CAP_NET_RAW, e.g. from "setcap cap_net_raw+ep", which only an attempt reveals
+func rawICMPAllowed() bool {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		return true
+	}
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
 }
 
 // executePing sends ICMP ping packets to the specified host
-func executePing(host string, count int, timeout, interval time.Duration) error {
+func executePing(host string, opts pingOptions) error {
+	ttl, tos := opts.ttl, opts.tos
+	if ttl < 1 || ttl > 255 {
+		return fmt.Errorf("TTL must be between 1 and 255, got %d", ttl)
+	}
+	if tos < 0 || tos > 255 {
+		return fmt.Errorf("ToS must be between 0 and 255, got %d", tos)
+	}
+
 	// Create a new ping instance
-	pinger, err := ping.NewPinger(host)
+	pinger, err := probing.NewPinger(host)
 	if err != nil {
 		return fmt.Errorf("failed to create pinger: %v", err)
 	}
 
 	// Set ping configuration
-	pinger.Count = count
-	pinger.Timeout = timeout
-	pinger.Interval = interval
-	pinger.SetPrivileged(true) // Required to send ICMP packets
+	pinger.Count = opts.count
+	pinger.Timeout = opts.timeout
+	pinger.Interval = opts.interval
+	pinger.TTL = ttl
+	pinger.SetTrafficClass(uint8(tos))
+	pinger.SetPrivileged(opts.privileged)
+
+	// The per-packet lines and the statistics block can be silenced separately
+	var packets, summary io.Writer = os.Stdout, os.Stdout
+	var notes io.Writer = os.Stderr
+	if opts.summary {
+		packets = io.Discard
+	}
+	if opts.silent {
+		packets, summary, notes = io.Discard, io.Discard, io.Discard
+	}
+
+	// Flooding is reserved for privileged users; clamp instead of failing mid-run
+	if !opts.privileged && pinger.Interval < minUnprivilegedInterval {
+		fmt.Fprintf(notes, "Note: interval %s is below the %s minimum for unprivileged ping, using %s (use --privileged as root or with CAP_NET_RAW for shorter intervals)\n",
+			pinger.Interval, minUnprivilegedInterval, minUnprivilegedInterval)
+		pinger.Interval = minUnprivilegedInterval
+	}
+
+	// Pin the packets to a source address, or to an interface and its address
+	if opts.source != "" {
+		if net.ParseIP(opts.source) == nil {
+			return fmt.Errorf("invalid source address %q", opts.source)
+		}
+		pinger.Source = opts.source
+	}
+	if opts.iface != "" {
+		source, err := interfaceSourceAddress(opts.iface, pinger.IPAddr().IP.To4() != nil)
+		if err != nil {
+			return err
+		}
+		pinger.InterfaceName = opts.iface
+		pinger.Source = source
+	}
 
 	// Print ping result
-	fmt.Printf("PING %s (%s): %d data bytes\n", pinger.Addr(), pinger.IPAddr(), 64)
+	fmt.Fprintf(packets, "PING %s (%s): %d data bytes, ttl %d, tos 0x%02x\n", pinger.Addr(), pinger.IPAddr(), 64, ttl, tos)
+	if pinger.Source != "" {
+		fmt.Fprintf(packets, "From %s\n", pinger.Source)
+	}
+
+	// Print each reply and track jitter and rolling loss as packets come and go
+	quality := newPingQuality(opts.lossWindow)
+	ttls := &replyTTLs{}
+	var rtts []time.Duration
+	pinger.OnSend = func(pkt *probing.Packet) {
+		if report, ok := quality.onSend(pkt.Seq); ok {
+			fmt.Fprintln(packets, report)
+		}
+	}
+	pinger.OnRecv = func(pkt *probing.Packet) {
+		late := quality.onRecv(pkt.Seq, pkt.Rtt)
+		rtts = append(rtts, pkt.Rtt)
+		note := ""
+		if late {
+			note = " (out of order)"
+		}
+		if prev, changed := ttls.observe(pkt.TTL); changed {
+			note += fmt.Sprintf(" (ttl changed from %d)", prev)
+		}
+		fmt.Fprintf(packets, "%d bytes from %s: icmp_seq=%d ttl=%d time=%.3f ms%s\n",
+			pkt.Nbytes, pkt.IPAddr, pkt.Seq, pkt.TTL, pkt.Rtt.Seconds()*1000, note)
+	}
+	// A second reply to the same request points at a loop or a duplicating link
+	pinger.OnDuplicateRecv = func(pkt *probing.Packet) {
+		fmt.Fprintf(packets, "%d bytes from %s: icmp_seq=%d ttl=%d time=%.3f ms (DUP!)\n",
+			pkt.Nbytes, pkt.IPAddr, pkt.Seq, pkt.TTL, pkt.Rtt.Seconds()*1000)
+	}
 
 	// Start pinging
 	err = pinger.Run()
@@ -70,11 +264,255 @@ func executePing(host string, count int, timeout, interval time.Duration) error
 
 	// Get ping statistics
 	stats := pinger.Statistics()
-	fmt.Printf("\n--- %s ping statistics ---\n", host)
-	fmt.Printf("%d packets transmitted, %d packets received, %.1f%% packet loss\n",
+	fmt.Fprintln(packets)
+	fmt.Fprintf(summary, "--- %s ping statistics ---\n", host)
+	fmt.Fprintf(summary, "%d packets transmitted, %d packets received, %.1f%% packet loss\n",
 		stats.PacketsSent, stats.PacketsRecv, stats.PacketLoss)
-	fmt.Printf("round-trip min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f ms\n",
+	fmt.Fprintf(summary, "round-trip min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f ms\n",
 		stats.MinRtt.Seconds()*1000, stats.AvgRtt.Seconds()*1000, stats.MaxRtt.Seconds()*1000, stats.StdDevRtt.Seconds()*1000)
+	fmt.Fprintf(summary, "jitter = %.3f ms\n", quality.jitter().Seconds()*1000)
+	fmt.Fprintf(summary, "%d duplicates, %d out-of-order\n", stats.PacketsRecvDuplicates, quality.reordered())
+	if loss, window, ok := quality.finalLoss(); ok {
+		fmt.Fprintf(summary, "packet loss over last %d packets = %.1f%%\n", window, loss)
+	}
+	ttls.report(summary)
+	if opts.histogram && len(rtts) > 0 {
+		printRTTHistogram(summary, rtts)
+	}
 
+	if opts.checkLoss && stats.PacketLoss >= opts.lossThreshold {
+		return &exitError{code: 1, err: fmt.Errorf("%.1f%% packet loss reached the %g%% threshold", stats.PacketLoss, opts.lossThreshold)}
+	}
 	return nil
 }
+
+// interfaceSourceAddress returns the primary address of the named interface in the
+// given family, skipping IPv6 link-local addresses which need a zone to be usable
+func interfaceSourceAddress(name string, ipv4 bool) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("unknown interface %s: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to read addresses of %s: %v", name, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if (ipNet.IP.To4() != nil) != ipv4 || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipNet.IP.String(), nil
+	}
+	family := "IPv6"
+	if ipv4 {
+		family = "IPv4"
+	}
+	return "", fmt.Errorf("interface %s has no %s address", name, family)
+}
+
+// rttBucket counts the round-trip times in [low, high)
+type rttBucket struct {
+	low, high time.Duration
+	count     int
+}
+
+// rttHistogramBuckets and rttHistogramWidth set the number of rows and the length of
+// the longest bar in the histogram
+const (
+	rttHistogramBuckets = 10
+	rttHistogramWidth   = 40
+)
+
+// bucketRTTs spreads the round-trip times over n equally wide buckets between the
+// fastest and slowest reply; the slowest reply falls into the last bucket
+func bucketRTTs(rtts []time.Duration, n int) []rttBucket {
+	lo, hi := rtts[0], rtts[0]
+	for _, rtt := range rtts {
+		lo = min(lo, rtt)
+		hi = max(hi, rtt)
+	}
+	// All replies took the same time, a single bucket holds them all
+	if lo == hi {
+		return []rttBucket{{low: lo, high: hi, count: len(rtts)}}
+	}
+
+	width := (hi - lo + time.Duration(n) - 1) / time.Duration(n)
+	buckets := make([]rttBucket, n)
+	for i := range buckets {
+		buckets[i].low = lo + time.Duration(i)*width
+		buckets[i].high = buckets[i].low + width
+	}
+	for _, rtt := range rtts {
+		i := min(int((rtt-lo)/width), n-1)
+		buckets[i].count++
+	}
+	return buckets
+}
+
+// printRTTHistogram prints the distribution of round-trip times as one bar per bucket
+func printRTTHistogram(w io.Writer, rtts []time.Duration) {
+	buckets := bucketRTTs(rtts, rttHistogramBuckets)
+	peak := 0
+	for _, b := range buckets {
+		peak = max(peak, b.count)
+	}
+
+	fmt.Fprintln(w, "\nround-trip time histogram (ms):")
+	for _, b := range buckets {
+		bar := b.count * rttHistogramWidth / peak
+		if b.count > 0 && bar == 0 {
+			bar = 1
+		}
+		fmt.Fprintf(w, "  %9.3f - %9.3f | %-*s %d\n", b.low.Seconds()*1000, b.high.Seconds()*1000,
+			rttHistogramWidth, strings.Repeat("#", bar), b.count)
+	}
+}
+
+// pingQuality computes jitter (the mean difference between consecutive round-trip
+// times) and packet loss over a sliding window of recent packets. A packet counts as
+// lost in the window if no reply arrived before the next packet was sent.
+type pingQuality struct {
+	mu sync.Mutex
+
+	window   int
+	outcomes []bool // received/lost, one per finalized packet, oldest first
+	received map[int]bool
+	lastSeq  int
+	sent     int
+
+	prevRtt   time.Duration
+	hasPrev   bool
+	jitterSum time.Duration
+	jitterN   int
+
+	highestSeq int // highest sequence number answered so far
+	hasSeq     bool
+	outOfOrder int // replies that arrived after a reply to a later request
+}
+
+// newPingQuality creates a tracker reporting over windows of the given size
+func newPingQuality(window int) *pingQuality {
+	return &pingQuality{window: window, received: make(map[int]bool)}
+}
+
+// onSend records a sent packet. It finalizes the previous packet and, every window
+// packets, returns a report line with the rolling loss and current jitter.
+func (q *pingQuality) onSend(seq int) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	report, ok := "", false
+	if q.sent > 0 {
+		q.finalize(q.lastSeq)
+		if q.window > 0 && len(q.outcomes)%q.window == 0 {
+			report = fmt.Sprintf("--- last %d packets: %.1f%% loss, jitter %.3f ms ---",
+				q.window, q.windowLoss(), q.jitterLocked().Seconds()*1000)
+			ok = true
+		}
+	}
+	q.lastSeq = seq
+	q.sent++
+	return report, ok
+}
+
+// onRecv records a reply and updates the jitter from the previous round-trip time. It
+// reports whether the reply arrived out of order, after the reply to a later request.
+func (q *pingQuality) onRecv(seq int, rtt time.Duration) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	late := q.hasSeq && seqBefore(seq, q.highestSeq)
+	if late {
+		q.outOfOrder++
+	} else {
+		q.highestSeq = seq
+		q.hasSeq = true
+	}
+
+	q.received[seq] = true
+	if q.hasPrev {
+		diff := rtt - q.prevRtt
+		if diff < 0 {
+			diff = -diff
+		}
+		q.jitterSum += diff
+		q.jitterN++
+	}
+	q.prevRtt = rtt
+	q.hasPrev = true
+	return late
+}
+
+// seqBefore reports whether ICMP sequence number a was sent before b, allowing for the
+// 16-bit sequence number wrapping around
+func seqBefore(a, b int) bool {
+	return int16(uint16(a)-uint16(b)) < 0
+}
+
+// reordered returns the number of replies that arrived out of order
+func (q *pingQuality) reordered() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.outOfOrder
+}
+
+// finalize records whether the given packet was answered
+func (q *pingQuality) finalize(seq int) {
+	q.outcomes = append(q.outcomes, q.received[seq])
+	delete(q.received, seq)
+}
+
+// windowLoss returns the loss percentage over the most recent window of outcomes
+func (q *pingQuality) windowLoss() float64 {
+	recent := q.outcomes
+	if len(recent) > q.window {
+		recent = recent[len(recent)-q.window:]
+	}
+	if len(recent) == 0 {
+		return 0
+	}
+	lost := 0
+	for _, ok := range recent {
+		if !ok {
+			lost++
+		}
+	}
+	return 100 * float64(lost) / float64(len(recent))
+}
+
+// jitter returns the mean absolute difference between consecutive round-trip times
+func (q *pingQuality) jitter() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.jitterLocked()
+}
+
+func (q *pingQuality) jitterLocked() time.Duration {
+	if q.jitterN == 0 {
+		return 0
+	}
+	return q.jitterSum / time.Duration(q.jitterN)
+}
+
+// finalLoss finalizes the last packet once the run is over and returns the loss
+// over the last window of packets
+func (q *pingQuality) finalLoss() (float64, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.window <= 0 || q.sent == 0 {
+		return 0, 0, false
+	}
+	q.finalize(q.lastSeq)
+	q.sent = 0
+
+	window := q.window
+	if len(q.outcomes) < window {
+		window = len(q.outcomes)
+	}
+	return q.windowLoss(), window, true
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketRTTs(t *testing.T) {
+	ms := time.Millisecond
+	buckets := bucketRTTs([]time.Duration{10 * ms, 11 * ms, 12 * ms, 50 * ms}, 4)
+	if len(buckets) != 4 {
+		t.Fatalf("got %d buckets, want 4", len(buckets))
+	}
+	want := []int{3, 0, 0, 1}
+	for i, b := range buckets {
+		if b.count != want[i] {
+			t.Errorf("bucket %d (%s-%s): got %d, want %d", i, b.low, b.high, b.count, want[i])
+		}
+	}
+
+	same := bucketRTTs([]time.Duration{5 * ms, 5 * ms}, 4)
+	if len(same) != 1 || same[0].count != 2 {
+		t.Errorf("equal RTTs: got %+v, want one bucket of 2", same)
+	}
+}
+
+func TestPingQualityOutOfOrder(t *testing.T) {
+	q := newPingQuality(0)
+	for _, tt := range []struct {
+		seq  int
+		late bool
+	}{
+		{65534, false},
+		{0, false}, // the sequence number wrapped around
+		{65535, true},
+		{2, false},
+		{1, true},
+	} {
+		if late := q.onRecv(tt.seq, time.Millisecond); late != tt.late {
+			t.Errorf("onRecv(%d) late = %v, want %v", tt.seq, late, tt.late)
+		}
+	}
+	if got := q.reordered(); got != 2 {
+		t.Errorf("reordered() = %d, want 2", got)
+	}
+}
+
+func TestReplyTTLs(t *testing.T) {
+	var ttls replyTTLs
+	changes := 0
+	for _, ttl := range []int{54, 54, 0, 53, 53, 54} {
+		if prev, changed := ttls.observe(ttl); changed {
+			changes++
+			if prev == ttl {
+				t.Errorf("observe(%d) reported a change from the same TTL", ttl)
+			}
+		}
+	}
+	if changes != 2 || ttls.min != 53 || ttls.max != 54 {
+		t.Errorf("replyTTLs = %d changes, min %d, max %d; want 2 changes, min 53, max 54", changes, ttls.min, ttls.max)
+	}
+
+	for ttl, want := range map[int]int{30: 32, 54: 64, 64: 64, 115: 128, 240: 255} {
+		if got := guessInitialTTL(ttl); got != want {
+			t.Errorf("guessInitialTTL(%d) = %d, want %d", ttl, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// initialTTLs are the TTLs operating systems commonly start packets with: 64 for Linux
+// and macOS, 128 for Windows and 255 for many routers
+var initialTTLs = []int{32, 64, 128, 255}
+
+// longPathHops is the hop count beyond which a return path is unusually long; most
+// paths across the Internet take fewer than 30 hops
+const longPathHops = 30
+
+// replyTTLs follows the IP TTL of the replies. Every router on the way back decrements
+// it, so a TTL that changes mid-run means the return path changed, and the distance from
+// the sender's likely initial TTL estimates the length of the return path.
+type replyTTLs struct {
+	mu       sync.Mutex
+	last     int
+	min, max int
+	changes  int
+}
+
+// observe records the TTL of a reply and returns the previous reply's TTL when it differs
+func (r *replyTTLs) observe(ttl int) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ttl <= 0 {
+		// The system didn't report the TTL of this reply
+		return 0, false
+	}
+	if r.last == 0 {
+		r.last, r.min, r.max = ttl, ttl, ttl
+		return 0, false
+	}
+	prev := r.last
+	r.last = ttl
+	r.min = min(r.min, ttl)
+	r.max = max(r.max, ttl)
+	if ttl == prev {
+		return 0, false
+	}
+	r.changes++
+	return prev, true
+}
+
+// guessInitialTTL returns the smallest common initial TTL the observed TTL can have
+// started from
+func guessInitialTTL(ttl int) int {
+	for _, initial := range initialTTLs {
+		if ttl <= initial {
+			return initial
+		}
+	}
+	return 255
+}
+
+// report prints the TTL range of the replies with the estimated length of the return
+// path, and notes when the TTL varied or the path looks unusually long
+func (r *replyTTLs) report(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last == 0 {
+		return
+	}
+
+	initial := guessInitialTTL(r.max)
+	hops := initial - r.max
+	fmt.Fprintf(w, "reply ttl min/max = %d/%d, return path about %d hops (initial ttl %d)\n", r.min, r.max, hops, initial)
+	if r.changes > 0 {
+		fmt.Fprintf(w, "Note: the reply TTL changed %d times between %d and %d, so the return path changed during the run "+
+			"(route flapping, or load balancing over paths of different lengths)\n", r.changes, r.min, r.max)
+	}
+	if hops > longPathHops {
+		fmt.Fprintf(w, "Note: the replies crossed about %d hops, more than most paths take; the return path may differ "+
+			"from the forward path (compare with netro mtr)\n", hops)
+	}
+}
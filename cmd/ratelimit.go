@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// parseByteSize parses a human-friendly byte count such as "512", "100k", "2M" or "1g".
+// Suffixes are binary multiples (k = 1024), matching curl.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	multiplier := int64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return value * multiplier, nil
+}
+
+// newByteLimiter returns a limiter allowing bytesPerSecond, or nil for unlimited
+func newByteLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// rateLimitedReader throttles reads from the wrapped reader to the limiter's rate
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// newRateLimitedReader wraps r so it is read at most bytesPerSecond; zero means unlimited
+func newRateLimitedReader(r io.Reader, bytesPerSecond int64) io.Reader {
+	limiter := newByteLimiter(bytesPerSecond)
+	if limiter == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, limiter: limiter}
+}
+
+// rateLimitedReadCloser is a throttled reader that closes the reader it wraps
+type rateLimitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// newRateLimitedReadCloser is newRateLimitedReader for a request body, which must still
+// be closed once sent
+func newRateLimitedReadCloser(rc io.ReadCloser, bytesPerSecond int64) io.ReadCloser {
+	return rateLimitedReadCloser{Reader: newRateLimitedReader(rc, bytesPerSecond), Closer: rc}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// Never read more than one burst at a time so WaitN can always be satisfied
+	if len(p) > l.limiter.Burst() {
+		p = p[:l.limiter.Burst()]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := l.limiter.WaitN(context.Background(), n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// rateLimitedWriter throttles writes to the wrapped writer to the limiter's rate
+type rateLimitedWriter struct {
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+// newRateLimitedWriter wraps w so it is written at most bytesPerSecond; zero means unlimited
+func newRateLimitedWriter(w io.Writer, bytesPerSecond int64) io.Writer {
+	limiter := newByteLimiter(bytesPerSecond)
+	if limiter == nil {
+		return w
+	}
+	return &rateLimitedWriter{w: w, limiter: limiter}
+}
+
+func (l *rateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > l.limiter.Burst() {
+			chunk = chunk[:l.limiter.Burst()]
+		}
+		if err := l.limiter.WaitN(context.Background(), len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := l.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
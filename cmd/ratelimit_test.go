@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"512", 512},
+		{"100k", 100 * 1024},
+		{"100K", 100 * 1024},
+		{"2m", 2 * 1024 * 1024},
+		{"1g", 1024 * 1024 * 1024},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if err != nil {
+			t.Fatalf("parseByteSize(%q) returned an unexpected error: %v", tt.input, err)
+		}
+		if got != tt.expected {
+			t.Errorf("parseByteSize(%q) = %d, expected %d", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseByteSize_Invalid(t *testing.T) {
+	for _, input := range []string{"", "k", "abc", "-5", "1.5m"} {
+		if _, err := parseByteSize(input); err == nil {
+			t.Errorf("parseByteSize(%q) expected an error, got none", input)
+		}
+	}
+}
+
+// closeRecorder records whether it was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRateLimitedReadCloser(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader("payload")}
+	rc := newRateLimitedReadCloser(body, 1024)
+	data, err := io.ReadAll(rc)
+	if err != nil || string(data) != "payload" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+	rc.Close()
+	if !body.closed {
+		t.Error("closing the throttled body didn't close the wrapped body")
+	}
+}
@@ -4,6 +4,7 @@ Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -29,7 +30,35 @@ netro ifconfig
 
 # Perform a basic network diagnostic:
 netro netstat
+
+Aliases for frequently used command lines can be defined in $HOME/.netro.yaml:
+
+alias:
+  webcheck: "curl -I -L"
+
+"netro webcheck https://example.com" then runs "netro curl -I -L https://example.com".
+Aliases may use other aliases; built-in commands always take precedence.
 `,
+	// Validate global flags before any subcommand runs
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		if timeout < 0 {
+			return fmt.Errorf("invalid --timeout %s: must not be negative", timeout)
+		}
+
+		// Record the invocation before the command can exit on its own
+		logFile, _ := cmd.Flags().GetString("log-file")
+		if logFile != "" {
+			if err := startCommandLog(logFile, cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// Runs only when the command succeeded; failures are logged by Execute or exitCommand
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		finishCommandLog(0, nil)
+	},
 	// The action when no subcommand is provided
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Welcome to Netro! Use 'netro --help' to see available commands.")
@@ -40,17 +69,64 @@ netro netstat
 // This function is called by main.main() and sets the starting point for the CLI.
 // It only needs to be called once to initiate the root command and its subcommands.
 func Execute() {
+	// Aliases from the configuration file turn into the command lines they stand for
+	if err := applyAliases(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		// Commands can ask for a specific exit status, e.g. to report an HTTP status class
+		code := 1
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.code
+		}
+		finishCommandLog(code, err)
+		os.Exit(code)
 	}
 }
 
+// exitCommand ends the process with code, for commands that stop on their own rather
+// than return an error to Execute. The outcome is recorded in the --log-file first.
+func exitCommand(code int, err error) {
+	finishCommandLog(code, err)
+	os.Exit(code)
+}
+
+// fatalf prints an error message, as commands report failures, and exits with code 1
+func fatalf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Println(msg)
+	exitCommand(1, errors.New(msg))
+}
+
+// exitError is returned by a command to end the process with a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
 func init() {
 	// Persistent flags are global and can be used with any subcommand of 'netro'.
-	// Here you can define configuration-related flags for the entire application.
-	// Example: configuration file support can be added.
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.netro.yaml)")
+	// The configuration file is read before cobra parses the flags (see applyAliases).
+	rootCmd.PersistentFlags().String("config", "", "config file defining command aliases (default is $HOME/.netro.yaml)")
+
+	// The global timeout applies to every command that doesn't define its own --timeout;
+	// commands that do (nc, ping, doctor) receive it in their own flag wherever it's given.
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Default timeout for network operations (0 means no limit)")
+
+	// An audit trail for automation: one JSON line when a command starts and one when it ends
+	rootCmd.PersistentFlags().String("log-file", "", "Append a JSON-lines record of each invocation, its duration and outcome to this file; credentials in the arguments are masked")
 
 	// Local flags, specific to the root command itself (i.e., when no subcommands are provided).
 	// The 'toggle' flag is an example of a boolean flag.
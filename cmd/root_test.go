@@ -0,0 +1,39 @@
+/*
+Copyright © 2024 Sandarsh Devappa <sd@containeers.com>
+*/
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestGlobalTimeout(t *testing.T) {
+	var got time.Duration
+	probe := &cobra.Command{
+		Use: "timeout-probe",
+		Run: func(cmd *cobra.Command, args []string) {
+			got, _ = cmd.Flags().GetDuration("timeout")
+		},
+	}
+	probe.Flags().Duration("timeout", 5*time.Second, "")
+	rootCmd.AddCommand(probe)
+	global := rootCmd.PersistentFlags().Lookup("timeout")
+	t.Cleanup(func() {
+		rootCmd.RemoveCommand(probe)
+		rootCmd.SetArgs(nil)
+		global.Value.Set("0s")
+		global.Changed = false
+	})
+
+	// Given before the command name, --timeout still reaches the command's own flag
+	rootCmd.SetArgs([]string{"--timeout", "2s", "timeout-probe"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if got != 2*time.Second {
+		t.Errorf("got timeout %s, want 2s", got)
+	}
+}